@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/GGXXLL/rule"
 	"github.com/GGXXLL/rule/repository"
@@ -17,6 +18,12 @@ type Option func(*ruleEngine)
 type ruleEngine struct {
 	logger     log.Logger
 	repository rule.Repository
+	fallbacks  []Fallback
+	onFallback FallbackFunc
+	lastGood   lastGood
+	clock      rule.Clock
+	location   *time.Location
+	functions  map[string]interface{}
 }
 
 // WithRepository replace the rule.Repository
@@ -33,20 +40,67 @@ func WithLogger(logger log.Logger) Option {
 	}
 }
 
+// WithClock sets the clock of the time functions in the expressions, eg. to
+// pin now in tests. The clock of the context of Tenanter.WithContext
+// overrides it.
+func WithClock(clock rule.Clock) Option {
+	return func(c *ruleEngine) {
+		c.clock = clock
+	}
+}
+
+// WithLocation sets the location of the time functions in the expressions.
+// The location of the context of Tenanter.WithContext and the tz field of
+// the payload override it.
+func WithLocation(loc *time.Location) Option {
+	return func(c *ruleEngine) {
+		c.location = loc
+	}
+}
+
+// WithFunction makes fn available to the expressions of the rules as name,
+// see repository.WithFunction. The functions are compiled into the rules by
+// the repository built by DefaultRuleEngine, NewRuleEngine fails with them as
+// the repository of WithRepository has its own, see repository.WithFunction.
+func WithFunction(name string, fn interface{}) Option {
+	return func(c *ruleEngine) {
+		if c.functions == nil {
+			c.functions = make(map[string]interface{})
+		}
+		c.functions[name] = fn
+	}
+}
+
+// context returns ctx with the clock and the location of the engine, unless
+// ctx sets its own. The payload is left alone.
+func (c *ruleEngine) context(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	clock, loc := rule.TimeOf(ctx)
+	if clock == nil && c.clock != nil {
+		ctx = rule.WithClock(ctx, c.clock)
+	}
+	if loc == nil && c.location != nil {
+		ctx = rule.WithLocation(ctx, c.location)
+	}
+	return ctx
+}
+
 // DefaultRuleEngine will auto init rule.Repository and call its Watch method.
-// returns the Engine and clean func for stop Watch.
-func DefaultRuleEngine(driver rule.Driver, logger log.Logger) (Engine, func(), error) {
-	repo, err := repository.NewRepository(driver, repository.WithLogger(logger))
-	if err != nil {
-		return nil, nil, err
+// returns the Engine and clean func for stop Watch. The functions of the
+// options are registered to the repository.
+func DefaultRuleEngine(driver rule.Driver, logger log.Logger, opts ...Option) (Engine, func(), error) {
+	engine := newRuleEngine(append([]Option{WithLogger(logger)}, opts...))
+	repoOpts := []repository.Option{repository.WithLogger(logger)}
+	for name, fn := range engine.functions {
+		repoOpts = append(repoOpts, repository.WithFunction(name, fn))
 	}
-	engine, err := NewRuleEngine(
-		WithLogger(logger),
-		WithRepository(repo),
-	)
+	repo, err := repository.NewRepository(driver, repoOpts...)
 	if err != nil {
 		return nil, nil, err
 	}
+	engine.repository = repo
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -63,16 +117,24 @@ func DefaultRuleEngine(driver rule.Driver, logger log.Logger) (Engine, func(), e
 
 // NewRuleEngine returns Engine with Option.
 func NewRuleEngine(opt ...Option) (Engine, error) {
+	c := newRuleEngine(opt)
+	if c.repository == nil {
+		return nil, errors.New("repository is nil")
+	}
+	if len(c.functions) > 0 {
+		return nil, errors.New("functions are compiled by the repository, register them by repository.WithFunction")
+	}
+	return c, nil
+}
+
+func newRuleEngine(opts []Option) *ruleEngine {
 	c := &ruleEngine{
 		logger: log.NewJSONLogger(os.Stdout),
 	}
-	for _, o := range opt {
+	for _, o := range opts {
 		o(c)
 	}
-	if c.repository == nil {
-		return nil, errors.New("repository is nil")
-	}
-	return c, nil
+	return c
 }
 
 func (d *ruleEngine) Of(ruleName string) Tenanter {
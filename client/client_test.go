@@ -2,13 +2,17 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/GGXXLL/rule"
 	"github.com/GGXXLL/rule/driver"
 	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/repository"
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -50,3 +54,410 @@ rule:
 	}
 	assert.Equal(t, 1, r.Int("age"))
 }
+
+func TestDefaultRuleEngine_MemoryDriver(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("/rule/test/foo", `
+style: advanced
+rule:
+  - if: name == "a"
+    then:
+      age: 1`)
+
+	engine, clean, err := DefaultRuleEngine(drv, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	r, err := engine.Of("/rule/test/foo").Payload(dto.Payload{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, r.Int("age"))
+
+	drv.Put("/rule/test/foo", `
+style: advanced
+rule:
+  - if: name == "a"
+    then:
+      age: 2`)
+	assert.Eventually(t, func() bool {
+		r, err := engine.Of("/rule/test/foo").Payload(dto.Payload{"name": "a"})
+		return err == nil && r.Int("age") == 2
+	}, time.Second, 10*time.Millisecond)
+
+	drv.Delete("/rule/test/foo")
+	assert.Eventually(t, func() bool {
+		_, err := engine.Of("/rule/test/foo").Payload(dto.Payload{"name": "a"})
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDefaultRuleEngine_Explain(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("/rule/test/foo", `
+style: advanced
+rule:
+  - if: name == "a"
+    then:
+      age: 1
+  - if: name == "b"
+    then:
+      age: 2`)
+
+	engine, clean, err := DefaultRuleEngine(drv, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	r, trace, err := engine.Of("/rule/test/foo").Explain(dto.Payload{"name": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, r.Int("age"))
+	assert.Equal(t, "advanced", trace.Style)
+	assert.Len(t, trace.Children, 2)
+	assert.False(t, trace.Children[0].Matched)
+	assert.True(t, trace.Children[1].Matched)
+
+	_, trace, err = engine.Of("/rule/test/bar").Explain(dto.Payload{"name": "b"})
+	assert.Error(t, err)
+	assert.Nil(t, trace)
+}
+
+func TestDefaultRuleEngine_WithFunction(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("/rule/test/foo", `
+style: advanced
+rule:
+  - if: Upper(name) == "A"
+    then:
+      age: 1`)
+
+	engine, clean, err := DefaultRuleEngine(drv, log.NewNopLogger(), WithFunction("Upper", strings.ToUpper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	r, err := engine.Of("/rule/test/foo").Payload(dto.Payload{"name": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, r.Int("age"))
+
+	repo, err := repository.NewRepository(drv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRuleEngine(WithRepository(repo), WithFunction("Upper", strings.ToUpper))
+	assert.Error(t, err)
+}
+
+func TestDefaultRuleEngine_Errors(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("/rule/test/foo", `
+style: switch
+by: platform
+rule:
+  - case: ios
+    style: basic
+    rule:
+      age: 1
+default:
+  style: basic
+  rule:
+    age: 2`)
+
+	engine, clean, err := DefaultRuleEngine(drv, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	_, err = engine.Of("/rule/test/bar").Payload(dto.Payload{})
+	assert.True(t, errors.Is(err, rule.ErrRuleNotFound))
+
+	_, err = engine.Of("/rule/test/foo").Payload(dto.Payload{})
+	assert.True(t, errors.Is(err, rule.ErrMissingKey))
+	var evalErr *rule.EvalError
+	if assert.True(t, errors.As(err, &evalErr)) {
+		assert.Equal(t, "/rule/test/foo", evalErr.Rule)
+		assert.Equal(t, "platform", evalErr.Condition)
+	}
+
+	_, err = engine.Of("/rule/test/foo").Payload(dto.Payload{"platform": []string{"ios"}})
+	assert.True(t, errors.Is(err, rule.ErrKeyType))
+}
+
+func TestRuleEngine_Fallback(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("/rule/test/foo", `
+style: switch
+by: platform
+rule:
+  - case: ios
+    style: basic
+    rule:
+      age: 1
+default:
+  style: basic
+  rule:
+    age: 2
+default_result:
+  age: 3`)
+	repo, err := repository.NewRepository(drv, repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fallback struct {
+		source Source
+		err    error
+	}
+
+	cases := []struct {
+		name      string
+		fallbacks []Fallback
+		ruleName  string
+		def       dto.Data
+		source    Source
+		age       int
+		err       error
+	}{
+		{
+			name:     "rule",
+			ruleName: "/rule/test/foo",
+			source:   SourceRule,
+			age:      2,
+		},
+		{
+			name:      "last good fails too",
+			fallbacks: []Fallback{FallbackLastGood, FallbackDefaultResult},
+			ruleName:  "/rule/test/foo",
+			source:    SourceDefaultResult,
+			age:       3,
+			err:       rule.ErrMissingKey,
+		},
+		{
+			name:      "default result",
+			fallbacks: []Fallback{FallbackDefaultResult},
+			ruleName:  "/rule/test/foo",
+			source:    SourceDefaultResult,
+			age:       3,
+			err:       rule.ErrMissingKey,
+		},
+		{
+			name:      "default",
+			fallbacks: []Fallback{FallbackDefaultResult},
+			ruleName:  "/rule/test/bar",
+			def:       dto.Data{"age": 4},
+			source:    SourceDefault,
+			age:       4,
+			err:       rule.ErrRuleNotFound,
+		},
+		{
+			name:     "error",
+			ruleName: "/rule/test/foo",
+			err:      rule.ErrMissingKey,
+		},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var got []fallback
+			engine, err := NewRuleEngine(
+				WithRepository(repo),
+				WithLogger(log.NewNopLogger()),
+				WithFallback(c.fallbacks...),
+				WithFallbackFunc(func(ruleName string, source Source, err error) {
+					assert.Equal(t, c.ruleName, ruleName)
+					got = append(got, fallback{source: source, err: err})
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tenanter := engine.Of(c.ruleName)
+			if c.def != nil {
+				tenanter = tenanter.WithDefault(c.def)
+			}
+			// the last good result
+			_, _ = tenanter.Payload(dto.Payload{"platform": "ios"})
+			got = nil
+
+			payload := dto.Payload{}
+			if c.err == nil {
+				payload["platform"] = "android"
+			}
+			r, err := tenanter.Payload(payload)
+			if c.source == SourceRule && c.err != nil {
+				assert.True(t, errors.Is(err, c.err))
+				assert.Empty(t, got)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, c.age, r.Int("age"))
+			assert.Equal(t, c.source, SourceOf(r))
+			if c.err == nil {
+				assert.Empty(t, got)
+				return
+			}
+			if assert.Len(t, got, 1) {
+				assert.Equal(t, c.source, got[0].source)
+				assert.True(t, errors.Is(got[0].err, c.err))
+			}
+		})
+	}
+}
+
+func TestRuleEngine_FallbackLastGood(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("/rule/test/foo", `
+style: switch
+by: platform
+rule:
+  - case: ios
+    style: basic
+    rule:
+      age: 1
+  - case: android
+    style: basic
+    rule:
+      age: 2`)
+	repo, err := repository.NewRepository(drv, repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+	engine, err := NewRuleEngine(
+		WithRepository(repo),
+		WithLogger(log.NewNopLogger()),
+		WithFallback(FallbackLastGood),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = engine.Of("/rule/test/foo").Payload(dto.Payload{"platform": "ios"})
+	assert.NoError(t, err)
+
+	// the new version fails to calculate, the last good one is calculated
+	// with each payload
+	drv.Put("/rule/test/foo", `
+style: switch
+by: region
+rule:
+  - case: eu
+    style: basic
+    rule:
+      age: 3`)
+	assert.Eventually(t, func() bool {
+		r, err := engine.Of("/rule/test/foo").Payload(dto.Payload{"platform": "ios"})
+		return err == nil && SourceOf(r) == SourceLastGood
+	}, time.Second, 10*time.Millisecond)
+
+	for platform, age := range map[string]int{"ios": 1, "android": 2} {
+		r, err := engine.Of("/rule/test/foo").Payload(dto.Payload{"platform": platform})
+		if assert.NoError(t, err) {
+			assert.Equal(t, SourceLastGood, SourceOf(r))
+			assert.Equal(t, age, r.Int("age"))
+		}
+	}
+
+	// the last good one does not apply if it fails too
+	_, err = engine.Of("/rule/test/foo").Payload(dto.Payload{})
+	assert.ErrorIs(t, err, rule.ErrMissingKey)
+
+	drv.Delete("/rule/test/foo")
+	assert.Eventually(t, func() bool {
+		return repo.GetRuler("/rule/test/foo") == nil
+	}, time.Second, 10*time.Millisecond)
+	r, err := engine.Of("/rule/test/foo").Payload(dto.Payload{"platform": "android"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, r.Int("age"))
+	}
+}
+
+func TestRuleEngine_WithClock(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("/rule/test/foo", `
+style: advanced
+rule:
+  - if: IsBetween("2022-01-01", "2022-01-08")
+    then:
+      promotion: true
+  - if: true
+    then:
+      promotion: false`)
+	repo, err := repository.NewRepository(drv, repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewRuleEngine(
+		WithRepository(repo),
+		WithLogger(log.NewNopLogger()),
+		WithClock(rule.FixedClock(time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC))),
+		WithLocation(time.UTC),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := engine.Of("/rule/test/foo").Payload(dto.Payload{})
+	assert.NoError(t, err)
+	assert.True(t, r.Bool("promotion"))
+
+	// the clock of the evaluation takes precedence
+	later := rule.FixedClock(time.Date(2022, 1, 9, 0, 0, 0, 0, time.UTC))
+	r, err = engine.Of("/rule/test/foo").WithContext(rule.WithClock(context.Background(), later)).Payload(dto.Payload{})
+	assert.NoError(t, err)
+	assert.False(t, r.Bool("promotion"))
+}
+
+// customRuler is a custom ruler expecting a dto.Payload.
+type customRuler struct{ rule.Ruler }
+
+func (customRuler) Calculate(payload interface{}) (dto.Data, error) {
+	p, ok := payload.(dto.Payload)
+	if !ok {
+		return nil, fmt.Errorf("custom got %T", payload)
+	}
+	return dto.Data{"name": p["name"]}, nil
+}
+
+type customRepository struct{ rule.Repository }
+
+func (customRepository) GetRuler(ruleName string) rule.Ruler {
+	return customRuler{}
+}
+
+func TestRuleEngine_WithClock_Custom(t *testing.T) {
+	engine, err := NewRuleEngine(
+		WithRepository(customRepository{}),
+		WithLogger(log.NewNopLogger()),
+		WithClock(rule.FixedClock(time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC))),
+		WithLocation(time.UTC),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the payload is passed to the custom ruler as is
+	r, err := engine.Of("custom").Payload(dto.Payload{"name": "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", r.String("name"))
+
+	r, _, err = engine.Of("custom").WithContext(context.Background()).Explain(dto.Payload{"name": "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", r.String("name"))
+}
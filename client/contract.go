@@ -1,11 +1,26 @@
 package client
 
 import (
+	"context"
+
+	"github.com/GGXXLL/rule"
 	"github.com/GGXXLL/rule/contract"
+	"github.com/GGXXLL/rule/dto"
 )
 
 type Tenanter interface {
 	Payload(pl interface{}) (contract.ConfigAccessor, error)
+	// Explain calculates like Payload, and returns the trace of the nodes
+	// visited. The trace is returned even if the calculation fails.
+	Explain(pl interface{}) (contract.ConfigAccessor, *rule.Trace, error)
+	// WithDefault returns the Tenanter whose Payload returns data instead of
+	// the error if the rule is missing or fails to calculate, after the
+	// engine fallbacks. See SourceOf for where the result comes from.
+	WithDefault(data dto.Data) Tenanter
+	// WithContext returns the Tenanter evaluating in ctx, whose clock and
+	// location, see rule.WithClock and rule.WithLocation, take precedence
+	// over those of the engine.
+	WithContext(ctx context.Context) Tenanter
 }
 
 type Engine interface {
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/contract"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/internal/entity"
+)
+
+// Fallback is a way to get a result when the rule is missing or fails to
+// calculate.
+type Fallback int
+
+const (
+	// FallbackLastGood calculates the payload with the last compiled rule
+	// that has calculated successfully, eg. the previous version of a rule
+	// deleted or failing since. It does not apply if that one fails too.
+	FallbackLastGood Fallback = iota + 1
+	// FallbackDefaultResult returns the default_result in the rule document.
+	FallbackDefaultResult
+)
+
+// Source tells where the result comes from.
+type Source int
+
+const (
+	// SourceRule means the result is calculated by the rule.
+	SourceRule Source = iota
+	// SourceLastGood means the result is calculated by the last good rule, see
+	// FallbackLastGood.
+	SourceLastGood
+	// SourceDefaultResult means the result is the default_result of the rule.
+	SourceDefaultResult
+	// SourceDefault means the result is the data given to Tenanter.WithDefault.
+	SourceDefault
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceRule:
+		return "rule"
+	case SourceLastGood:
+		return "last_good"
+	case SourceDefaultResult:
+		return "default_result"
+	case SourceDefault:
+		return "default"
+	default:
+		return "unknown"
+	}
+}
+
+// FallbackFunc is called when the result falls back, err is the error of the rule.
+type FallbackFunc func(ruleName string, source Source, err error)
+
+// WithFallback sets the fallbacks tried in order when a rule is missing or
+// fails to calculate. The error is returned if none of them applies, which is
+// the default. The default of Tenanter.WithDefault is tried last.
+func WithFallback(fallbacks ...Fallback) Option {
+	return func(c *ruleEngine) {
+		c.fallbacks = fallbacks
+	}
+}
+
+// WithFallbackFunc sets the callback observing the fallbacks.
+func WithFallbackFunc(f FallbackFunc) Option {
+	return func(c *ruleEngine) {
+		c.onFallback = f
+	}
+}
+
+// SourceOf returns where the result of Tenanter.Payload comes from.
+func SourceOf(c contract.ConfigAccessor) Source {
+	if a, ok := c.(*accessor); ok {
+		return a.source
+	}
+	return SourceRule
+}
+
+// accessor is the result along with its source.
+type accessor struct {
+	contract.ConfigAccessor
+	source Source
+}
+
+// lastGood keeps the last compiled rule of each rule name that has
+// calculated successfully, rather than its result which depends on the
+// payload.
+type lastGood struct {
+	m sync.Map
+}
+
+func (l *lastGood) store(ruleName string, ruler rule.Ruler) {
+	l.m.Store(ruleName, ruler)
+}
+
+func (l *lastGood) load(ruleName string) (rule.Ruler, bool) {
+	ruler, ok := l.m.Load(ruleName)
+	if !ok {
+		return nil, false
+	}
+	return ruler.(rule.Ruler), true
+}
+
+// fallback returns the result of the first applicable fallback for the
+// payload.
+func (r *ofRule) fallback(pl interface{}) (dto.Data, Source, bool) {
+	for _, f := range r.d.fallbacks {
+		switch f {
+		case FallbackLastGood:
+			if ruler, ok := r.d.lastGood.load(r.ruleName); ok {
+				if data, err := rule.CalculateContext(r.d.context(r.ctx), ruler, pl); err == nil {
+					return data, SourceLastGood, true
+				}
+			}
+		case FallbackDefaultResult:
+			if data, ok := r.d.defaultResult(r.ruleName); ok {
+				return data, SourceDefaultResult, true
+			}
+		}
+	}
+	if r.hasDefault {
+		return r.defaultData, SourceDefault, true
+	}
+	return nil, SourceRule, false
+}
+
+// defaultResult returns the default_result of the rule, kept by the
+// repository if it is a rule.DefaultResultRepository, or else parsed from the
+// raw value.
+func (d *ruleEngine) defaultResult(ruleName string) (dto.Data, bool) {
+	var repo rule.DefaultResultRepository
+	if rule.AsRepository(d.repository, &repo) {
+		return repo.DefaultResult(ruleName)
+	}
+	raw := d.repository.GetRaw(ruleName)
+	if raw == nil {
+		return nil, false
+	}
+	data, ok, err := entity.DefaultResult(bytes.NewReader(raw))
+	return data, ok && err == nil
+}
+
+func (d *ruleEngine) keepsLastGood() bool {
+	for _, f := range d.fallbacks {
+		if f == FallbackLastGood {
+			return true
+		}
+	}
+	return false
+}
@@ -1,31 +1,105 @@
 package client
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/GGXXLL/rule"
 	"github.com/GGXXLL/rule/config"
 	"github.com/GGXXLL/rule/contract"
+	"github.com/GGXXLL/rule/dto"
 	"github.com/knadh/koanf/providers/confmap"
 	"github.com/pkg/errors"
 )
 
 type ofRule struct {
-	d        *ruleEngine
-	ruleName string
+	d           *ruleEngine
+	ruleName    string
+	defaultData dto.Data
+	hasDefault  bool
+	ctx         context.Context
 }
 
 func (r *ofRule) Payload(pl interface{}) (contract.ConfigAccessor, error) {
+	ruler, calculated, err := r.calculate(pl)
+	if err != nil {
+		data, source, ok := r.fallback(pl)
+		if !ok {
+			return nil, err
+		}
+		if r.d.onFallback != nil {
+			r.d.onFallback(r.ruleName, source, err)
+		}
+		c, err := newAccessor(data)
+		if err != nil {
+			return nil, err
+		}
+		return &accessor{ConfigAccessor: c, source: source}, nil
+	}
+	if r.d.keepsLastGood() {
+		r.d.lastGood.store(r.ruleName, ruler)
+	}
+	return newAccessor(calculated)
+}
+
+// calculate returns the compiled rule along with its result.
+func (r *ofRule) calculate(pl interface{}) (rule.Ruler, dto.Data, error) {
+	ruler := r.d.repository.GetRuler(r.ruleName)
+	if ruler == nil {
+		return nil, nil, r.notFound()
+	}
+
+	calculated, err := rule.CalculateContext(r.d.context(r.ctx), ruler, pl)
+	if err != nil {
+		return nil, nil, r.named(err)
+	}
+	return ruler, calculated, nil
+}
+
+func (r *ofRule) WithDefault(data dto.Data) Tenanter {
+	return &ofRule{
+		d:           r.d,
+		ruleName:    r.ruleName,
+		defaultData: data,
+		hasDefault:  true,
+		ctx:         r.ctx,
+	}
+}
+
+func (r *ofRule) WithContext(ctx context.Context) Tenanter {
+	c := *r
+	c.ctx = ctx
+	return &c
+}
+
+func (r *ofRule) Explain(pl interface{}) (contract.ConfigAccessor, *rule.Trace, error) {
 	ruler := r.d.repository.GetRuler(r.ruleName)
 	if ruler == nil {
-		return nil, fmt.Errorf("no suitable configuration found for %s", r.ruleName)
+		return nil, nil, r.notFound()
 	}
 
-	calculated, err := rule.Calculate(ruler, pl)
+	calculated, trace, err := rule.ExplainContext(r.d.context(r.ctx), ruler, pl)
 	if err != nil {
-		return nil, err
+		return nil, trace, r.named(err)
 	}
+	c, err := newAccessor(calculated)
+	return c, trace, err
+}
+
+func (r *ofRule) notFound() error {
+	return fmt.Errorf("%w: %s", rule.ErrRuleNotFound, r.ruleName)
+}
+
+// named sets the rule name of the EvalError.
+func (r *ofRule) named(err error) error {
+	var evalErr *rule.EvalError
+	if errors.As(err, &evalErr) {
+		evalErr.Rule = r.ruleName
+	}
+	return err
+}
 
+func newAccessor(calculated dto.Data) (contract.ConfigAccessor, error) {
 	c, err := config.NewConfig(config.WithProviderLayer(confmap.Provider(calculated, "."), nil))
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot load from map")
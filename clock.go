@@ -0,0 +1,69 @@
+package rule
+
+import (
+	"context"
+	"time"
+
+	"github.com/GGXXLL/rule/dto"
+	"github.com/antonmedv/expr"
+)
+
+// Clock returns the current time of the evaluations, eg. time.Now.
+type Clock func() time.Time
+
+// FixedClock returns the Clock always at t, eg. to pin now in tests.
+func FixedClock(t time.Time) Clock {
+	return func() time.Time {
+		return t
+	}
+}
+
+type timingKey struct{}
+
+// timing is the clock and the location of the evaluations in a context.
+type timing struct {
+	clock    Clock
+	location *time.Location
+}
+
+// WithClock returns the context whose evaluations take the time of clock,
+// instead of that of the repository, see CalculateContext.
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	t, _ := ctx.Value(timingKey{}).(timing)
+	t.clock = clock
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// WithLocation returns the context whose evaluations are in loc, instead of
+// that of the repository, unless the payload has a tz field.
+func WithLocation(ctx context.Context, loc *time.Location) context.Context {
+	t, _ := ctx.Value(timingKey{}).(timing)
+	t.location = loc
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// TimeOf returns the clock and the location set on the context, nil if not
+// set.
+func TimeOf(ctx context.Context) (Clock, *time.Location) {
+	if ctx == nil {
+		return nil, nil
+	}
+	t, _ := ctx.Value(timingKey{}).(timing)
+	return t.clock, t.location
+}
+
+// CalculateContext calculates like Calculate, the time functions of the
+// expressions take the clock and the location of ctx if any. The payload is
+// passed to the Ruler as is, ctx is carried by the Trace given to an
+// Explainer, see Trace.Context.
+func CalculateContext(ctx context.Context, rules Ruler, env interface{}) (dto.Data, error) {
+	e, ok := rules.(Explainer)
+	if clock, loc := TimeOf(ctx); !ok || clock == nil && loc == nil {
+		return Calculate(rules, env)
+	}
+	// a misused env is reported by Calculate
+	if _, ok := env.(expr.Option); ok {
+		return Calculate(rules, env)
+	}
+	return e.Explain(env, &Trace{discard: true, ctx: ctx})
+}
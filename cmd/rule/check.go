@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GGXXLL/rule/internal/entity"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// result is the outcome of validating a rule file.
+type result struct {
+	File string `json:"file"`
+	// Line is the line of the failure, 0 if unknown.
+	Line  int    `json:"line,omitempty"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (r result) String() string {
+	if r.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", r.File, r.Line, r.Error)
+	}
+	return fmt.Sprintf("%s: %s", r.File, r.Error)
+}
+
+func (e *env) validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	asJSON := flags.Bool("json", false, "print results as json")
+	root := flags.String("root", "", "resolve the referenced and extended rules in `dir`")
+	prefix := flags.String("prefix", "", "the etcd key prefix of the rules in the root dir")
+	segmentPrefix := flags.String("segment-prefix", "", "the etcd key prefix of the segments in the root dir")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(e.stderr, "usage: rule validate [-json] [-root dir [-prefix prefix] [-segment-prefix prefix]] file...")
+		return exitUsage
+	}
+	var resolver *localResolver
+	if *root != "" {
+		rules, err := localRules(*root, *prefix)
+		if err != nil {
+			fmt.Fprintln(e.stderr, err)
+			return exitFail
+		}
+		resolver = newLocalResolver(rules, *segmentPrefix)
+	}
+	var results []result
+	for _, name := range flags.Args() {
+		results = append(results, e.checkFile(name, resolver))
+	}
+	return e.report(results, *asJSON, false)
+}
+
+func (e *env) test(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	asJSON := flags.Bool("json", false, "print results as json")
+	prefix := flags.String("prefix", "", "the etcd key prefix of the rules in the dirs")
+	segmentPrefix := flags.String("segment-prefix", "", "the etcd key prefix of the segments in the dirs, they are not tested as rules")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	dirs := flags.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	var results []result
+	for _, dir := range dirs {
+		rules, err := localRules(dir, *prefix)
+		if err != nil {
+			results = append(results, result{File: dir, Error: err.Error()})
+			continue
+		}
+		resolver := newLocalResolver(rules, *segmentPrefix)
+		files := make([]string, 0, len(rules))
+		for key, r := range rules {
+			if !resolver.isSegment(key) {
+				files = append(files, r.path)
+			}
+		}
+		sort.Strings(files)
+		for _, name := range files {
+			results = append(results, e.checkFile(name, resolver))
+		}
+	}
+	return e.report(results, *asJSON, true)
+}
+
+func (e *env) report(results []result, asJSON bool, summary bool) int {
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+	}
+	if asJSON {
+		if results == nil {
+			results = []result{}
+		}
+		e.printJSON(results)
+	} else {
+		for _, r := range results {
+			if !r.OK {
+				fmt.Fprintln(e.stdout, r)
+			}
+		}
+		if summary {
+			fmt.Fprintf(e.stdout, "%d files, %d failed\n", len(results), failed)
+		}
+	}
+	if failed > 0 {
+		return exitFail
+	}
+	return exitOK
+}
+
+// checkFile validates the rule file, "-" reads from stdin. The referenced and
+// extended rules are looked up by the resolver if it is not nil.
+func (e *env) checkFile(name string, resolver *localResolver) result {
+	var (
+		b   []byte
+		err error
+	)
+	if name == "-" {
+		b, err = io.ReadAll(e.stdin)
+	} else {
+		b, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return result{File: name, Error: err.Error()}
+	}
+	return check(name, b, resolver.options(name)...)
+}
+
+func check(name string, b []byte, opts ...entity.Option) result {
+	err := entity.ValidateRules(bytes.NewReader(b), append(opts, entity.WithFunctions(functions))...)
+	if err == nil {
+		return result{File: name, OK: true}
+	}
+	r := result{File: name, Error: err.Error()}
+	var invalid *entity.ErrInvalidRules
+	if errors.As(err, &invalid) {
+		r.Line = lineOf(b, invalid)
+	}
+	return r
+}
+
+var yamlLine = regexp.MustCompile(`line (\d+)`)
+
+// lineOf locates the failing test case or def in the document. Syntax errors
+// carry the line in their message.
+func lineOf(b []byte, invalid *entity.ErrInvalidRules) int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return line
+		}
+		return 0
+	}
+	if invalid.Key == "" || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return 0
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != invalid.Key {
+			continue
+		}
+		value := root.Content[i+1]
+		if invalid.Key == "tests" && value.Kind == yaml.SequenceNode && invalid.Index < len(value.Content) {
+			return value.Content[invalid.Index].Line
+		}
+		return root.Content[i].Line
+	}
+	return 0
+}
+
+// ruleFiles returns the yaml files under dir, hidden files and directories
+// are skipped.
+func ruleFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && (filepath.Ext(path) == ".yaml" || filepath.Ext(path) == ".yml") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
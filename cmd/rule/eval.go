@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/internal/entity"
+	"github.com/GGXXLL/rule/internal/function"
+	"github.com/pkg/errors"
+)
+
+// sets collects repeated -set key=value flags.
+type sets []string
+
+func (s *sets) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *sets) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("%q is not key=value", v)
+	}
+	*s = append(*s, v)
+	return nil
+}
+
+type evalOutput struct {
+	Result dto.Data    `json:"result"`
+	Trace  *rule.Trace `json:"trace,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func (e *env) eval(args []string) int {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	asJSON := flags.Bool("json", false, "print errors as json")
+	explain := flags.Bool("explain", false, "print the trace along with the result")
+	payloadJSON := flags.String("payload", "", "payload as a json object, read from stdin if neither -payload nor -set is given")
+	now := flags.String("now", "", "evaluate at the time, as a date, a date time or RFC3339")
+	var kvs sets
+	flags.Var(&kvs, "set", "set a payload key, the value is parsed as json if possible, can be repeated")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(e.stderr, "usage: rule eval [-json] [-explain] [-now time] [-payload json] [-set key=value]... file")
+		return exitUsage
+	}
+
+	out, err := e.evaluate(flags.Arg(0), *payloadJSON, kvs, *explain, *now)
+	if err != nil {
+		if *asJSON {
+			e.printJSON(evalOutput{Error: err.Error()})
+		} else {
+			fmt.Fprintln(e.stderr, err)
+		}
+		return exitFail
+	}
+	if *explain {
+		e.printJSON(out)
+	} else {
+		e.printJSON(out.Result)
+	}
+	return exitOK
+}
+
+func (e *env) evaluate(name, payloadJSON string, kvs sets, explain bool, now string) (evalOutput, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return evalOutput{}, err
+	}
+	defer f.Close()
+	ruler, err := entity.NewRules(f, entity.WithFunctions(functions))
+	if err != nil {
+		return evalOutput{}, err
+	}
+
+	p, err := e.payload(payloadJSON, kvs)
+	if err != nil {
+		return evalOutput{}, err
+	}
+	ctx := context.Background()
+	if now != "" {
+		t, err := function.Parse(now, time.Local)
+		if err != nil {
+			return evalOutput{}, err
+		}
+		ctx = rule.WithClock(ctx, rule.FixedClock(t))
+	}
+
+	if !explain {
+		data, err := rule.CalculateContext(ctx, ruler, p)
+		return evalOutput{Result: data}, err
+	}
+	data, trace, err := rule.ExplainContext(ctx, ruler, p)
+	return evalOutput{Result: data, Trace: trace}, err
+}
+
+// payload decodes the payload the same way as a POST request to dto.Decoder.
+func (e *env) payload(payloadJSON string, kvs sets) (dto.Payload, error) {
+	payload := make(dto.Payload)
+	var b []byte
+	switch {
+	case payloadJSON != "":
+		b = []byte(payloadJSON)
+	case len(kvs) == 0:
+		var err error
+		b, err = io.ReadAll(e.stdin)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read payload")
+		}
+	}
+	if len(strings.TrimSpace(string(b))) > 0 {
+		if err := json.Unmarshal(b, &payload); err != nil {
+			return nil, errors.Wrap(err, "payload is not a json object")
+		}
+	}
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		var v interface{}
+		if err := json.Unmarshal([]byte(parts[1]), &v); err != nil {
+			v = parts[1]
+		}
+		payload[parts[0]] = v
+	}
+	return payload, nil
+}
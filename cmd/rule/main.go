@@ -0,0 +1,88 @@
+// Command rule checks and evaluates rule files without a running repository,
+// and syncs a directory of rule files with an etcd prefix.
+//
+// Usage:
+//
+//	rule validate [-json] file...
+//	rule test [-json] [dir...]
+//	rule eval [-json] [-explain] [-now time] [-payload json] [-set key=value]... file
+//	rule diff -prefix prefix [-endpoints endpoints] [-json] [dir]
+//	rule push -prefix prefix [-endpoints endpoints] [-json] [-delete] [dir]
+//	rule pull -prefix prefix [-endpoints endpoints] [-json] [-delete] [dir]
+//
+// The exit code is 0 on success, 1 if a rule is invalid, fails to evaluate,
+// or differs from etcd, and 2 on usage errors.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	exitOK    = 0
+	exitFail  = 1
+	exitUsage = 2
+)
+
+const usage = `usage: rule <command> [flags] [args]
+
+commands:
+  validate  validate rule files, including tests and def
+  test      validate all rule files in directories
+  eval      evaluate a rule file against a json payload
+  diff      show the difference between a directory and an etcd prefix
+  push      validate a directory and write it to an etcd prefix in a transaction
+  pull      write the rules under an etcd prefix to a directory
+`
+
+// functions are available to the expressions in addition to the builtin ones,
+// a build of the command with custom functions registers them here.
+var functions = map[string]interface{}{}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	env := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+	switch args[0] {
+	case "validate":
+		return env.validate(args[1:])
+	case "test":
+		return env.test(args[1:])
+	case "eval":
+		return env.eval(args[1:])
+	case "diff":
+		return env.diff(args[1:])
+	case "push":
+		return env.push(args[1:])
+	case "pull":
+		return env.pull(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// env is the io of a command.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (e *env) printJSON(v interface{}) {
+	enc := json.NewEncoder(e.stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validRule = `
+style: advanced
+rule:
+  - if: name == "foo"
+    then:
+      i: 1
+  - if: true
+    then:
+      i: 2
+tests:
+  - given:
+      url: http://example.com?name=foo
+    expect: i == 1
+`
+
+const failingRule = `
+style: advanced
+rule:
+  - if: name == "foo"
+    then:
+      i: 1
+  - if: true
+    then:
+      i: 2
+tests:
+  - given:
+      url: http://example.com?name=foo
+    expect: i == 1
+  - given:
+      url: http://example.com?name=bar
+    expect: i == 1
+`
+
+func writeRules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runCmd(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestValidate(t *testing.T) {
+	dir := writeRules(t, map[string]string{
+		"ok.yaml":     validRule,
+		"fail.yaml":   failingRule,
+		"syntax.yaml": "style: basic\nrule:\n  a: [1\n",
+		"def.yaml":    "style: basic\nrule:\n  a: 1\ndef:\n  type: object\n  properties:\n    a:\n      type: string\n",
+	})
+
+	code, stdout, _ := runCmd("", "validate", filepath.Join(dir, "ok.yaml"))
+	assert.Equal(t, exitOK, code)
+	assert.Empty(t, stdout)
+
+	code, stdout, _ = runCmd("", "validate", filepath.Join(dir, "ok.yaml"), filepath.Join(dir, "fail.yaml"))
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, filepath.Join(dir, "fail.yaml")+":14: ")
+	assert.NotContains(t, stdout, "ok.yaml")
+
+	code, stdout, _ = runCmd("", "validate", "-json", filepath.Join(dir, "def.yaml"), filepath.Join(dir, "syntax.yaml"))
+	assert.Equal(t, exitFail, code)
+	var results []result
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &results))
+	if assert.Len(t, results, 2) {
+		assert.False(t, results[0].OK)
+		assert.Equal(t, 4, results[0].Line)
+		assert.False(t, results[1].OK)
+		assert.NotEmpty(t, results[1].Error)
+	}
+
+	code, _, _ = runCmd(validRule, "validate", "-")
+	assert.Equal(t, exitOK, code)
+
+	code, _, _ = runCmd("", "validate")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestTest(t *testing.T) {
+	dir := writeRules(t, map[string]string{
+		"a/ok.yaml":      validRule,
+		"b/fail.yml":     failingRule,
+		".hidden/x.yaml": failingRule,
+		"README.md":      "not a rule",
+	})
+
+	code, stdout, _ := runCmd("", "test", dir)
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, "fail.yml:14: ")
+	assert.Contains(t, stdout, "2 files, 1 failed")
+
+	code, stdout, _ = runCmd("", "test", filepath.Join(dir, "a"))
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "1 files, 0 failed")
+}
+
+func TestTest_Extends(t *testing.T) {
+	dir := writeRules(t, map[string]string{
+		"base.yaml": "style: basic\nrule:\n  a: 1\n",
+		"eu.yaml": `extends: /rules/base
+style: basic
+rule:
+  b: 2
+tests:
+  - given:
+      url: http://example.com
+    expect: a == 1 && b == 2
+`,
+		"us.yaml": "\n\nextends: /rules/missing\nstyle: basic\n",
+	})
+
+	code, stdout, _ := runCmd("", "test", "-prefix", "/rules", dir)
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, "us.yaml:3: missing parent /rules/missing")
+	assert.Contains(t, stdout, "3 files, 1 failed")
+
+	code, _, _ = runCmd("", "validate", "-root", dir, "-prefix", "/rules", filepath.Join(dir, "eu.yaml"))
+	assert.Equal(t, exitOK, code)
+	code, stdout, _ = runCmd("", "validate", filepath.Join(dir, "eu.yaml"))
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, "missing parent /rules/base")
+}
+
+func TestTest_Segments(t *testing.T) {
+	dir := writeRules(t, map[string]string{
+		"vip.yaml": `style: advanced
+rule:
+  - if: segment.vip
+    then:
+      sms: 1
+  - if: true
+    then:
+      sms: 0
+tests:
+  - given:
+      url: http://example.com?level=6
+    expect: sms == 1
+`,
+		"segments/vip.yaml": `"level >= \"5\""`,
+	})
+
+	code, stdout, _ := runCmd("", "test", "-prefix", "/rules", "-segment-prefix", "/rules/segments/", dir)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "1 files, 0 failed")
+
+	code, _, _ = runCmd("", "validate", "-root", dir, "-prefix", "/rules", "-segment-prefix", "/rules/segments/", filepath.Join(dir, "vip.yaml"))
+	assert.Equal(t, exitOK, code)
+	code, stdout, _ = runCmd("", "validate", filepath.Join(dir, "vip.yaml"))
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, "unknown segment vip")
+}
+
+func TestEval(t *testing.T) {
+	dir := writeRules(t, map[string]string{"rule.yaml": validRule})
+	file := filepath.Join(dir, "rule.yaml")
+
+	cases := []struct {
+		name   string
+		stdin  string
+		args   []string
+		code   int
+		expect string
+	}{
+		{"stdin", `{"name": "foo"}`, []string{file}, exitOK, `{"i": 1}`},
+		{"payload flag", "", []string{"-payload", `{"name": "bar"}`, file}, exitOK, `{"i": 2}`},
+		{"set flag", "", []string{"-set", "name=foo", file}, exitOK, `{"i": 1}`},
+		{"invalid payload", "", []string{"-payload", "[1]", file}, exitFail, ""},
+		{"missing file", "", []string{"-payload", "{}", filepath.Join(dir, "none.yaml")}, exitFail, ""},
+		{"json error", "", []string{"-json", "-payload", "x", file}, exitFail, ""},
+		{"no file", "", nil, exitUsage, ""},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			code, stdout, _ := runCmd(c.stdin, append([]string{"eval"}, c.args...)...)
+			assert.Equal(t, c.code, code)
+			if c.expect != "" {
+				assert.JSONEq(t, c.expect, stdout)
+			}
+		})
+	}
+
+	code, stdout, _ := runCmd("", "eval", "-explain", "-set", "name=foo", file)
+	assert.Equal(t, exitOK, code)
+	var out evalOutput
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &out))
+	assert.Equal(t, "advanced", out.Trace.Style)
+
+	code, stdout, _ = runCmd("", "eval", "-json", "-payload", "x", file)
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, `"error"`)
+}
+
+func TestRun(t *testing.T) {
+	code, _, stderr := runCmd("", "foo")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "unknown command")
+
+	code, _, _ = runCmd("")
+	assert.Equal(t, exitUsage, code)
+}
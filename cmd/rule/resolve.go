@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/internal/entity"
+	"gopkg.in/yaml.v3"
+)
+
+// localResolver resolves the rules referenced or extended by the local rule
+// files, named like push does. The files keyed under the segment prefix are
+// the shared segments, like repository.WithSegmentPrefix.
+type localResolver struct {
+	rules         map[string]*localRule
+	rulers        map[string]rule.Ruler
+	segmentPrefix string
+}
+
+func newLocalResolver(rules map[string]*localRule, segmentPrefix string) *localResolver {
+	return &localResolver{rules: rules, rulers: make(map[string]rule.Ruler), segmentPrefix: segmentPrefix}
+}
+
+// isSegment reports whether the key is a segment rather than a rule.
+func (l *localResolver) isSegment(key string) bool {
+	return l != nil && l.segmentPrefix != "" && strings.HasPrefix(key, l.segmentPrefix)
+}
+
+// segment returns the condition of the named segment file, the value is a
+// yaml string or the condition as is.
+func (l *localResolver) segment(name string) (string, bool) {
+	r, ok := l.rules[l.segmentPrefix+name]
+	if !ok {
+		return "", false
+	}
+	var cond string
+	if err := yaml.Unmarshal(r.value, &cond); err != nil || cond == "" {
+		cond = strings.TrimSpace(string(r.value))
+	}
+	return cond, cond != ""
+}
+
+// segments returns the option looking up the segment files, if any.
+func (l *localResolver) segments() []entity.Option {
+	if l.segmentPrefix == "" {
+		return nil
+	}
+	return []entity.Option{entity.WithSegments(l.segment)}
+}
+
+// GetRuler compiles the rule file on first use, it returns nil if the file
+// is missing or invalid.
+func (l *localResolver) GetRuler(ruleName string) rule.Ruler {
+	if ruler, ok := l.rulers[ruleName]; ok {
+		return ruler
+	}
+	var ruler rule.Ruler
+	if r, ok := l.rules[ruleName]; ok {
+		opts := append(l.segments(), entity.WithFunctions(functions))
+		compiled, err := entity.NewRules(bytes.NewReader(r.value), opts...)
+		if err == nil {
+			entity.Resolve(compiled, l)
+			ruler = compiled
+		}
+	}
+	l.rulers[ruleName] = ruler
+	return ruler
+}
+
+// options returns the options validating the rule file at path.
+func (l *localResolver) options(path string) []entity.Option {
+	if l == nil {
+		return nil
+	}
+	opts := append(l.segments(), entity.WithResolver(l))
+	for name, r := range l.rules {
+		if r.path == path {
+			opts = append(opts, entity.WithName(name))
+		}
+	}
+	return opts
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GGXXLL/rule/driver"
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// change is the difference of a rule between the source and the target of a
+// sync. Revision is the etcd mod revision of the remote rule, 0 if absent.
+type change struct {
+	Key      string   `json:"key"`
+	Op       string   `json:"op"`
+	File     string   `json:"file,omitempty"`
+	Diff     []string `json:"diff,omitempty"`
+	Revision int64    `json:"-"`
+	Value    []byte   `json:"-"`
+}
+
+func (c change) String() string {
+	return fmt.Sprintf("%s %s", c.Op, c.Key)
+}
+
+type syncOutput struct {
+	Changes []change `json:"changes"`
+	Invalid []result `json:"invalid,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// localRule is a rule file under the synced directory.
+type localRule struct {
+	path  string
+	value []byte
+}
+
+// remoteRule is a rule under the etcd prefix.
+type remoteRule struct {
+	value    []byte
+	revision int64
+}
+
+// syncFlags are the flags shared by push, pull and diff.
+type syncFlags struct {
+	*flag.FlagSet
+	endpoints     string
+	prefix        string
+	segmentPrefix string
+	timeout       time.Duration
+	maxTxnOps     int
+	asJSON        bool
+	delete        bool
+}
+
+func newSyncFlags(name string, e *env) *syncFlags {
+	f := &syncFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.SetOutput(e.stderr)
+	f.StringVar(&f.endpoints, "endpoints", "127.0.0.1:2379", "comma separated etcd endpoints")
+	f.StringVar(&f.prefix, "prefix", "", "etcd prefix of the rules, eg. /example")
+	f.StringVar(&f.segmentPrefix, "segment-prefix", "", "etcd prefix of the segments, eg. /example/segments/, they are pushed without validation")
+	f.DurationVar(&f.timeout, "timeout", 10*time.Second, "timeout of etcd requests")
+	f.IntVar(&f.maxTxnOps, "max-txn-ops", defaultMaxTxnOps, "the --max-txn-ops of the etcd server, the most changes pushed at once")
+	f.BoolVar(&f.asJSON, "json", false, "print results as json")
+	return f
+}
+
+func (f *syncFlags) parse(args []string, e *env) (string, bool) {
+	if err := f.Parse(args); err != nil {
+		return "", false
+	}
+	if f.prefix == "" || f.NArg() > 1 {
+		fmt.Fprintf(e.stderr, "usage: rule %s -prefix prefix [flags] [dir]\n", f.Name())
+		return "", false
+	}
+	f.prefix = strings.TrimSuffix(f.prefix, "/")
+	if f.NArg() == 0 {
+		return ".", true
+	}
+	return f.Arg(0), true
+}
+
+func (f *syncFlags) connect() (*clientv3.Client, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(f.endpoints, ","),
+		DialTimeout: f.timeout,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot connect to etcd")
+	}
+	return cli, nil
+}
+
+// load reads the local and the remote rules.
+func (f *syncFlags) load(dir string) (map[string]*localRule, map[string]*remoteRule, error) {
+	local, err := localRules(dir, f.prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	cli, err := f.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	remote, err := remoteRules(ctx, cli, f.prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	return local, remote, nil
+}
+
+func (e *env) diff(args []string) int {
+	f := newSyncFlags("diff", e)
+	dir, ok := f.parse(args, e)
+	if !ok {
+		return exitUsage
+	}
+	local, remote, err := f.load(dir)
+	if err != nil {
+		return e.syncFailed(f, syncOutput{Error: err.Error()})
+	}
+	changes := plan(local, remote, true)
+	for i := range changes {
+		var before, after []byte
+		if r, ok := remote[changes[i].Key]; ok {
+			before = r.value
+		}
+		if l, ok := local[changes[i].Key]; ok {
+			after = l.value
+		}
+		changes[i].Diff = lineDiff(string(before), string(after))
+	}
+	if f.asJSON {
+		e.printJSON(syncOutput{Changes: nonNil(changes)})
+	} else {
+		for _, c := range changes {
+			fmt.Fprintln(e.stdout, c)
+			for _, line := range c.Diff {
+				fmt.Fprintln(e.stdout, "\t"+line)
+			}
+		}
+	}
+	if len(changes) > 0 {
+		return exitFail
+	}
+	return exitOK
+}
+
+func (e *env) push(args []string) int {
+	f := newSyncFlags("push", e)
+	f.BoolVar(&f.delete, "delete", false, "delete remote rules missing in dir")
+	dir, ok := f.parse(args, e)
+	if !ok {
+		return exitUsage
+	}
+	local, err := localRules(dir, f.prefix)
+	if err != nil {
+		return e.syncFailed(f, syncOutput{Error: err.Error()})
+	}
+	var invalid []result
+	resolver := newLocalResolver(local, f.segmentPrefix)
+	for key, l := range local {
+		if resolver.isSegment(key) {
+			continue
+		}
+		if r := check(l.path, l.value, resolver.options(l.path)...); !r.OK {
+			invalid = append(invalid, r)
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Slice(invalid, func(i, j int) bool { return invalid[i].File < invalid[j].File })
+		return e.syncFailed(f, syncOutput{Invalid: invalid, Error: "invalid rules, nothing is pushed"})
+	}
+
+	cli, err := f.connect()
+	if err != nil {
+		return e.syncFailed(f, syncOutput{Error: err.Error()})
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	remote, err := remoteRules(ctx, cli, f.prefix)
+	if err != nil {
+		return e.syncFailed(f, syncOutput{Error: err.Error()})
+	}
+	changes := plan(local, remote, f.delete)
+	if err := commit(ctx, cli, changes, f.maxTxnOps); err != nil {
+		return e.syncFailed(f, syncOutput{Error: err.Error()})
+	}
+	e.printChanges(f, changes)
+	return exitOK
+}
+
+func (e *env) pull(args []string) int {
+	f := newSyncFlags("pull", e)
+	f.BoolVar(&f.delete, "delete", false, "delete local rule files missing in etcd")
+	dir, ok := f.parse(args, e)
+	if !ok {
+		return exitUsage
+	}
+	local, remote, err := f.load(dir)
+	if err != nil {
+		return e.syncFailed(f, syncOutput{Error: err.Error()})
+	}
+	// the remote is the source, so the ops are reversed
+	var changes []change
+	for _, c := range plan(local, remote, true) {
+		switch c.Op {
+		case opCreate:
+			if !f.delete {
+				continue
+			}
+			c.Op = opDelete
+			if err := os.Remove(c.File); err != nil {
+				return e.syncFailed(f, syncOutput{Changes: changes, Error: err.Error()})
+			}
+		case opUpdate:
+			if err := os.WriteFile(c.File, remote[c.Key].value, 0o644); err != nil {
+				return e.syncFailed(f, syncOutput{Changes: changes, Error: err.Error()})
+			}
+		case opDelete:
+			c.Op = opCreate
+			c.File = filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(c.Key, f.prefix+"/"))+".yaml")
+			if err := os.MkdirAll(filepath.Dir(c.File), 0o755); err != nil {
+				return e.syncFailed(f, syncOutput{Changes: changes, Error: err.Error()})
+			}
+			if err := os.WriteFile(c.File, remote[c.Key].value, 0o644); err != nil {
+				return e.syncFailed(f, syncOutput{Changes: changes, Error: err.Error()})
+			}
+		}
+		changes = append(changes, c)
+	}
+	e.printChanges(f, changes)
+	return exitOK
+}
+
+func (e *env) printChanges(f *syncFlags, changes []change) {
+	if f.asJSON {
+		e.printJSON(syncOutput{Changes: nonNil(changes)})
+		return
+	}
+	for _, c := range changes {
+		fmt.Fprintln(e.stdout, c)
+	}
+}
+
+func (e *env) syncFailed(f *syncFlags, out syncOutput) int {
+	if f.asJSON {
+		out.Changes = nonNil(out.Changes)
+		e.printJSON(out)
+		return exitFail
+	}
+	for _, c := range out.Changes {
+		fmt.Fprintln(e.stdout, c)
+	}
+	for _, r := range out.Invalid {
+		fmt.Fprintln(e.stdout, r)
+	}
+	fmt.Fprintln(e.stderr, out.Error)
+	return exitFail
+}
+
+func nonNil(changes []change) []change {
+	if changes == nil {
+		return []change{}
+	}
+	return changes
+}
+
+// localRules reads the rule files under dir, keyed the same way as the
+// driver.FileDriver but under the prefix, eg. dir/foo/bar.yaml is
+// prefix/foo/bar.
+func localRules(dir, prefix string) (map[string]*localRule, error) {
+	files, err := ruleFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]*localRule, len(files))
+	for _, path := range files {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		key := prefix + "/" + strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+		if other, ok := rules[key]; ok {
+			return nil, fmt.Errorf("%s and %s are both %s", other.path, path, key)
+		}
+		value, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rules[key] = &localRule{path: path, value: value}
+	}
+	return rules, nil
+}
+
+func remoteRules(ctx context.Context, cli *clientv3.Client, prefix string) (map[string]*remoteRule, error) {
+	drv := driver.NewEtcdDriver(cli, driver.WithPrefix(prefix+"/"), driver.WithLogger(log.NewNopLogger()))
+	kvs, err := drv.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]*remoteRule, len(kvs))
+	for _, kv := range kvs {
+		rules[kv.Key] = &remoteRule{value: kv.Value, revision: kv.Revision}
+	}
+	return rules, nil
+}
+
+// plan returns the changes that make the remote rules the same as the local
+// ones, sorted by key. Remote rules missing locally are deleted only if
+// withDelete is true.
+func plan(local map[string]*localRule, remote map[string]*remoteRule, withDelete bool) []change {
+	var changes []change
+	for key, l := range local {
+		r, ok := remote[key]
+		switch {
+		case !ok:
+			changes = append(changes, change{Key: key, Op: opCreate, File: l.path, Value: l.value})
+		case !bytes.Equal(l.value, r.value):
+			changes = append(changes, change{Key: key, Op: opUpdate, File: l.path, Value: l.value, Revision: r.revision})
+		}
+	}
+	if withDelete {
+		for key, r := range remote {
+			if _, ok := local[key]; !ok {
+				changes = append(changes, change{Key: key, Op: opDelete, Revision: r.revision})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// defaultMaxTxnOps is the default --max-txn-ops of the etcd server.
+const defaultMaxTxnOps = 128
+
+// commit applies the changes in a single transaction. It fails without
+// writing anything if any of the remote rules has changed since planned, or
+// if there are more changes than maxOps, the operations an etcd transaction
+// can hold.
+func commit(ctx context.Context, cli *clientv3.Client, changes []change, maxOps int) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if len(changes) > maxOps {
+		return fmt.Errorf("%d changes exceed the %d operations of an etcd transaction (--max-txn-ops of the server), nothing is pushed: push fewer rules at a time, or raise -max-txn-ops along with the server", len(changes), maxOps)
+	}
+	var (
+		cmps []clientv3.Cmp
+		ops  []clientv3.Op
+	)
+	for _, c := range changes {
+		switch c.Op {
+		case opCreate:
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(c.Key), "=", 0))
+			ops = append(ops, clientv3.OpPut(c.Key, string(c.Value)))
+		case opUpdate:
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(c.Key), "=", c.Revision))
+			ops = append(ops, clientv3.OpPut(c.Key, string(c.Value)))
+		case opDelete:
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(c.Key), "=", c.Revision))
+			ops = append(ops, clientv3.OpDelete(c.Key))
+		}
+	}
+	resp, err := cli.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return errors.Wrap(err, "cannot commit transaction")
+	}
+	if !resp.Succeeded {
+		return errors.New("remote rules changed during push, nothing is pushed")
+	}
+	return nil
+}
+
+// lineDiff returns the lines removed from a with "-" and added in b with "+",
+// based on the longest common subsequence. Common lines are omitted.
+func lineDiff(a, b string) []string {
+	x, y := splitLines(a), splitLines(b)
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+x[i])
+			i++
+		default:
+			lines = append(lines, "+"+y[j])
+			j++
+		}
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
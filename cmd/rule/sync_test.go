@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestPlan(t *testing.T) {
+	local := map[string]*localRule{
+		"/p/a": {path: "a.yaml", value: []byte("a")},
+		"/p/b": {path: "b.yaml", value: []byte("b2")},
+		"/p/c": {path: "c.yaml", value: []byte("c")},
+	}
+	remote := map[string]*remoteRule{
+		"/p/b": {value: []byte("b1"), revision: 3},
+		"/p/c": {value: []byte("c"), revision: 4},
+		"/p/d": {value: []byte("d"), revision: 5},
+	}
+
+	assert.Equal(t, []change{
+		{Key: "/p/a", Op: opCreate, File: "a.yaml", Value: []byte("a")},
+		{Key: "/p/b", Op: opUpdate, File: "b.yaml", Value: []byte("b2"), Revision: 3},
+	}, plan(local, remote, false))
+
+	changes := plan(local, remote, true)
+	assert.Len(t, changes, 3)
+	assert.Equal(t, change{Key: "/p/d", Op: opDelete, Revision: 5}, changes[2])
+}
+
+func TestLineDiff(t *testing.T) {
+	cases := []struct {
+		name   string
+		a, b   string
+		expect []string
+	}{
+		{"same", "a\nb\n", "a\nb\n", nil},
+		{"create", "", "a\nb\n", []string{"+a", "+b"}},
+		{"delete", "a\nb\n", "", []string{"-a", "-b"}},
+		{"change", "a\nb\nc\n", "a\nx\nc\n", []string{"-b", "+x"}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, c.expect, lineDiff(c.a, c.b))
+		})
+	}
+}
+
+func TestLocalRules(t *testing.T) {
+	dir := writeRules(t, map[string]string{
+		"foo.yaml":     "a",
+		"bar/baz.yml":  "b",
+		".git/x.yaml":  "c",
+		"ignored.json": "d",
+	})
+	rules, err := localRules(dir, "/p")
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, []byte("a"), rules["/p/foo"].value)
+	assert.Equal(t, []byte("b"), rules["/p/bar/baz"].value)
+
+	dir = writeRules(t, map[string]string{"foo.yaml": "a", "foo.yml": "b"})
+	_, err = localRules(dir, "/p")
+	assert.Error(t, err)
+}
+
+func TestPush_Invalid(t *testing.T) {
+	dir := writeRules(t, map[string]string{"ok.yaml": validRule, "fail.yaml": failingRule})
+
+	// validation fails before connecting to etcd
+	code, stdout, stderr := runCmd("", "push", "-prefix", "/p", "-endpoints", "127.0.0.1:1", dir)
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, "fail.yaml:14: ")
+	assert.Contains(t, stderr, "nothing is pushed")
+
+	code, _, _ = runCmd("", "push", dir)
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestPush_Segments(t *testing.T) {
+	dir := writeRules(t, map[string]string{
+		"vip.yaml":          "style: advanced\nrule:\n  - if: segment.vip\n    then:\n      sms: 1\n",
+		"segments/vip.yaml": "level >= 5",
+	})
+
+	// segments are not rules, push fails only when connecting to etcd
+	code, _, stderr := runCmd("", "push", "-prefix", "/p", "-segment-prefix", "/p/segments/", "-endpoints", "127.0.0.1:1", "-timeout", "100ms", dir)
+	assert.Equal(t, exitFail, code)
+	assert.NotContains(t, stderr, "nothing is pushed")
+
+	code, stdout, _ := runCmd("", "push", "-prefix", "/p", "-endpoints", "127.0.0.1:1", dir)
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, filepath.Join("segments", "vip.yaml"))
+}
+
+func TestPushPullDiff(t *testing.T) {
+	endpoints := os.Getenv("ETCD_ADDR")
+	if endpoints == "" {
+		t.Skipf("set ETCD_ADDR to run TestPushPullDiff")
+	}
+	prefix := "/rule/test/cmd/" + filepath.Base(t.TempDir())
+	dir := writeRules(t, map[string]string{"a.yaml": validRule, "b/c.yaml": validRule})
+	flags := []string{"-endpoints", endpoints, "-prefix", prefix}
+
+	code, stdout, _ := runCmd("", append(append([]string{"diff"}, flags...), dir)...)
+	assert.Equal(t, exitFail, code)
+	assert.Contains(t, stdout, "create "+prefix+"/a")
+	assert.Contains(t, stdout, "create "+prefix+"/b/c")
+
+	code, _, _ = runCmd("", append(append([]string{"push"}, flags...), dir)...)
+	assert.Equal(t, exitOK, code)
+
+	code, stdout, _ = runCmd("", append(append([]string{"diff"}, flags...), dir)...)
+	assert.Equal(t, exitOK, code)
+	assert.Empty(t, stdout)
+
+	// remove a local rule, only deleted remotely with -delete
+	assert.NoError(t, os.Remove(filepath.Join(dir, "a.yaml")))
+	code, _, _ = runCmd("", append(append([]string{"push"}, flags...), dir)...)
+	assert.Equal(t, exitOK, code)
+	code, _, _ = runCmd("", append(append([]string{"diff"}, flags...), dir)...)
+	assert.Equal(t, exitFail, code)
+	code, stdout, _ = runCmd("", append(append([]string{"push", "-delete"}, flags...), dir)...)
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "delete "+prefix+"/a")
+
+	out := t.TempDir()
+	code, _, _ = runCmd("", append(append([]string{"pull"}, flags...), out)...)
+	assert.Equal(t, exitOK, code)
+	b, err := os.ReadFile(filepath.Join(out, "b", "c.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, validRule, string(b))
+	_, err = os.Stat(filepath.Join(out, "a.yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	// clean up
+	assert.NoError(t, os.Remove(filepath.Join(dir, "b", "c.yaml")))
+	code, _, _ = runCmd("", append(append([]string{"push", "-delete"}, flags...), dir)...)
+	assert.Equal(t, exitOK, code)
+}
+
+func TestCommit_TooMany(t *testing.T) {
+	changes := make([]change, 3)
+	for i := range changes {
+		changes[i] = change{Key: fmt.Sprintf("/p/%d", i), Op: opCreate, Value: []byte("a")}
+	}
+	// rejected before reaching etcd
+	err := commit(context.Background(), nil, changes, 2)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "3 changes exceed the 2 operations")
+	}
+}
+
+func TestCommit_Conflict(t *testing.T) {
+	endpoints := os.Getenv("ETCD_ADDR")
+	if endpoints == "" {
+		t.Skipf("set ETCD_ADDR to run TestCommit_Conflict")
+	}
+	cli, err := (&syncFlags{endpoints: endpoints, timeout: 5 * time.Second}).connect()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cli.Close()
+	ctx := context.Background()
+	prefix := "/rule/test/cmd/" + filepath.Base(t.TempDir())
+	resp, err := cli.Put(ctx, prefix+"/a", "a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cli.Delete(ctx, prefix+"/", clientv3.WithPrefix())
+
+	// a is modified after planned, the whole batch is rejected
+	_, err = cli.Put(ctx, prefix+"/a", "a2")
+	assert.NoError(t, err)
+	err = commit(ctx, cli, []change{
+		{Key: prefix + "/a", Op: opUpdate, Value: []byte("a3"), Revision: resp.Header.Revision},
+		{Key: prefix + "/b", Op: opCreate, Value: []byte("b")},
+	}, defaultMaxTxnOps)
+	assert.Error(t, err)
+	remote, err := remoteRules(ctx, cli, prefix)
+	assert.NoError(t, err)
+	assert.Len(t, remote, 1)
+	assert.Equal(t, []byte("a2"), remote[prefix+"/a"].value)
+}
@@ -2,59 +2,40 @@ package driver
 
 import (
 	"context"
-	"regexp"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/GGXXLL/rule"
+	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 type EtcdDriver struct {
+	Options
 	client *clientv3.Client
-	prefix string
-	regexp *regexp.Regexp
-	rev    int64
+
+	// mu guards rev and known, which describe the last state emitted.
+	mu    sync.Mutex
+	rev   int64
+	known map[string]int64
 
 	ch chan *rule.KeyValue
 
 	once sync.Once
-
-	limit int64
-}
-
-type Option func(driver *EtcdDriver)
-
-// WithPrefix specifies the path prefix to listen.
-func WithPrefix(p string) Option {
-	return func(driver *EtcdDriver) {
-		driver.prefix = p
-	}
-}
-
-// WithLimit set the clientv3.WithLimit.
-func WithLimit(limit int64) Option {
-	return func(driver *EtcdDriver) {
-		driver.limit = limit
-	}
-}
-
-// WithRegex filter the path to listen by regexp.
-func WithRegex(regexp *regexp.Regexp) Option {
-	return func(r *EtcdDriver) {
-		r.regexp = regexp
-	}
 }
 
 func NewEtcdDriver(client *clientv3.Client, opts ...Option) *EtcdDriver {
 	d := &EtcdDriver{
-		client: client,
-		rev:    0,
-		ch:     make(chan *rule.KeyValue),
-		limit:  1000,
+		Options: defaultOptions(),
+		client:  client,
+		rev:     0,
+		known:   make(map[string]int64),
+		ch:      make(chan *rule.KeyValue),
 	}
 	for _, opt := range opts {
-		opt(d)
+		opt(&d.Options)
 	}
 	if d.prefix == "" {
 		panic("etcd driver must define prefix")
@@ -75,28 +56,59 @@ func (r *EtcdDriver) One(ctx context.Context, key string) ([]byte, error) {
 }
 
 // All returns all kvs that conform to the specified prefix and regular expression.
+// The following Watch starts from the revision of the returned kvs.
 func (r *EtcdDriver) All(ctx context.Context) ([]*rule.KeyValue, error) {
+	kvs, rev, err := r.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rev = rev
+	r.known = make(map[string]int64, len(kvs))
+	for _, kv := range kvs {
+		r.known[kv.Key] = kv.Revision
+	}
+	return kvs, nil
+}
+
+// Revision returns the etcd revision the driver has emitted changes up to.
+func (r *EtcdDriver) Revision() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rev
+}
+
+// list returns all kvs at a single revision, along with the revision.
+func (r *EtcdDriver) list(ctx context.Context) ([]*rule.KeyValue, int64, error) {
+	var rev int64
 	kvs := make([]*rule.KeyValue, 0)
 	key := r.prefix
 	for {
-		resp, err := r.client.Get(ctx, key, clientv3.WithRange(clientv3.GetPrefixRangeEnd(r.prefix)), clientv3.WithLimit(r.limit))
+		opts := []clientv3.OpOption{clientv3.WithRange(clientv3.GetPrefixRangeEnd(r.prefix)), clientv3.WithLimit(r.limit)}
+		if rev != 0 {
+			// read the following pages at the same revision as the first one
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		resp, err := r.client.Get(ctx, key, opts...)
 		if err != nil {
-			return nil, errors.Wrapf(err, "prefix not found %s", r.prefix)
+			return nil, 0, errors.Wrapf(err, "prefix not found %s", r.prefix)
 		}
-		if r.rev == 0 {
-			r.rev = resp.Header.Revision
+		if rev == 0 {
+			rev = resp.Header.Revision
 		}
 		for _, ev := range resp.Kvs {
 			if r.regexp != nil && !r.regexp.Match(ev.Key) {
 				continue
 			}
 			kvs = append(kvs, &rule.KeyValue{
-				Key:   string(ev.Key),
-				Value: ev.Value,
+				Key:      string(ev.Key),
+				Value:    ev.Value,
+				Revision: ev.ModRevision,
 			})
 		}
 		if !resp.More {
-			return kvs, nil
+			return kvs, rev, nil
 		}
 		// move to next key
 		key = string(append(resp.Kvs[len(resp.Kvs)-1].Key, 0))
@@ -110,30 +122,191 @@ func (r *EtcdDriver) Watch(ctx context.Context) rule.KvWatchChan {
 	return r.ch
 }
 
+// watch keeps watching until ctx is done. When the watch breaks, eg. the
+// revision has been compacted or the connection is lost, it re-lists the
+// prefix, emits the difference and watches again from the new revision. The
+// backoff keeps growing while the watch breaks again soon after a resync, it
+// is reset only once a watch has lasted the maximum backoff.
 func (r *EtcdDriver) watch(ctx context.Context) {
 	defer close(r.ch)
-	rch := r.client.Watch(ctx, r.prefix, clientv3.WithPrefix(), clientv3.WithRev(r.rev))
+	b := &backoff{min: r.minBackoff, max: r.maxBackoff}
 	for {
-		select {
-		case wr := <-rch:
-			if wr.Err() != nil {
-				r.ch <- &rule.KeyValue{
-					Err: wr.Err(),
-				}
+		start := time.Now()
+		err := r.watchOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		b.watched(time.Since(start))
+		if errors.Is(err, errCompacted) {
+			_ = level.Warn(r.logger).Log("msg", "etcd revision compacted, resync", "prefix", r.prefix, "err", err)
+		} else {
+			wait := b.next()
+			_ = level.Error(r.logger).Log("msg", "etcd watch broken, resync", "prefix", r.prefix, "err", err, "backoff", wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			err = r.resync(ctx)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			wait := b.next()
+			_ = level.Error(r.logger).Log("msg", "etcd resync failed", "prefix", r.prefix, "err", err, "backoff", wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
 				return
 			}
+		}
+	}
+}
+
+// backoff is the interval between the attempts to recover the watch, it
+// doubles after each attempt up to max.
+type backoff struct {
+	min, max time.Duration
+	last     time.Duration
+}
+
+// next returns the interval before the next attempt.
+func (b *backoff) next() time.Duration {
+	switch {
+	case b.last == 0:
+		b.last = b.min
+	case b.last*2 > b.max:
+		b.last = b.max
+	default:
+		b.last *= 2
+	}
+	return b.last
+}
+
+// watched resets the backoff if the watch has lasted max, a watch breaking
+// sooner, eg. flapping right after each resync, keeps the backoff growing.
+func (b *backoff) watched(d time.Duration) {
+	if d >= b.max {
+		b.last = 0
+	}
+}
+
+var errCompacted = errors.New("revision compacted")
+
+// watchOnce watches from the next revision until the watch breaks.
+func (r *EtcdDriver) watchOnce(ctx context.Context) error {
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	// without a listed revision, watch from now on
+	rev := r.Revision()
+	if rev != 0 {
+		rev++
+	}
+	rch := r.client.Watch(wctx, r.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev))
+	for {
+		select {
+		case wr, ok := <-rch:
+			if !ok {
+				return errors.New("watch channel closed")
+			}
+			if wr.CompactRevision != 0 {
+				return errors.Wrapf(errCompacted, "compact revision %d", wr.CompactRevision)
+			}
+			if err := wr.Err(); err != nil {
+				return err
+			}
 			for _, ev := range wr.Events {
 				if r.regexp != nil && !r.regexp.Match(ev.Kv.Key) {
 					continue
 				}
-				kv := &rule.KeyValue{Key: string(ev.Kv.Key), Value: ev.Kv.Value}
+				kv := &rule.KeyValue{Key: string(ev.Kv.Key), Value: ev.Kv.Value, Revision: ev.Kv.ModRevision}
 				if ev.Type == clientv3.EventTypeDelete {
 					kv.Type = rule.EventTypeDelete
 				}
-				r.ch <- kv
+				if !r.emit(ctx, kv) {
+					return ctx.Err()
+				}
+			}
+			if !wr.IsProgressNotify() && wr.Header.Revision != 0 {
+				r.mu.Lock()
+				if wr.Header.Revision > r.rev {
+					r.rev = wr.Header.Revision
+				}
+				r.mu.Unlock()
 			}
 		case <-ctx.Done():
-			return
+			return ctx.Err()
 		}
 	}
 }
+
+// resync lists the prefix and emits the changes since the last emitted state,
+// including a delete event for each vanished key.
+func (r *EtcdDriver) resync(ctx context.Context) error {
+	kvs, rev, err := r.list(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	changes := diff(r.known, kvs, rev)
+	r.mu.Unlock()
+	for _, kv := range changes {
+		if !r.emit(ctx, kv) {
+			return ctx.Err()
+		}
+	}
+	r.mu.Lock()
+	r.rev = rev
+	r.mu.Unlock()
+	return nil
+}
+
+// emit sends the kv and records it as known, returns false if ctx is done.
+func (r *EtcdDriver) emit(ctx context.Context, kv *rule.KeyValue) bool {
+	select {
+	case r.ch <- kv:
+	case <-ctx.Done():
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if kv.Type == rule.EventTypeDelete {
+		delete(r.known, kv.Key)
+	} else {
+		r.known[kv.Key] = kv.Revision
+	}
+	if kv.Revision > r.rev {
+		r.rev = kv.Revision
+	}
+	return true
+}
+
+// diff returns the events that turn known, a map from key to mod revision,
+// into kvs listed at rev.
+func diff(known map[string]int64, kvs []*rule.KeyValue, rev int64) []*rule.KeyValue {
+	var changes []*rule.KeyValue
+	exists := make(map[string]struct{}, len(kvs))
+	for _, kv := range kvs {
+		exists[kv.Key] = struct{}{}
+		if modRev, ok := known[kv.Key]; ok && modRev == kv.Revision {
+			continue
+		}
+		changes = append(changes, kv)
+	}
+	var deleted []string
+	for key := range known {
+		if _, ok := exists[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	for _, key := range deleted {
+		changes = append(changes, &rule.KeyValue{Key: key, Type: rule.EventTypeDelete, Revision: rev})
+	}
+	return changes
+}
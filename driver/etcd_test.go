@@ -9,6 +9,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/GGXXLL/rule"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -17,7 +20,7 @@ var prefix = "rule/test/etcd"
 
 func TestMain(m *testing.M) {
 	if os.Getenv("ETCD_ADDR") == "" {
-		os.Exit(0)
+		os.Exit(m.Run())
 	}
 	cli, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(os.Getenv("ETCD_ADDR"), ",")})
 	if err != nil {
@@ -39,6 +42,9 @@ func TestMain(m *testing.M) {
 }
 
 func TestNewEtcdDriver_One(t *testing.T) {
+	if etcdClient == nil {
+		t.Skipf("set ETCD_ADDR to run TestNewEtcdDriver_One")
+	}
 	d := NewEtcdDriver(etcdClient, WithPrefix(prefix))
 	v, err := d.One(context.Background(), prefix+"/a")
 	if err != nil {
@@ -50,6 +56,9 @@ func TestNewEtcdDriver_One(t *testing.T) {
 }
 
 func TestNewEtcdDriver_All(t *testing.T) {
+	if etcdClient == nil {
+		t.Skipf("set ETCD_ADDR to run TestNewEtcdDriver_All")
+	}
 	d := NewEtcdDriver(etcdClient, WithPrefix(prefix))
 	kvs, err := d.All(context.Background())
 	if err != nil {
@@ -64,6 +73,9 @@ func TestNewEtcdDriver_All(t *testing.T) {
 }
 
 func TestNewEtcdDriver_Watch(t *testing.T) {
+	if etcdClient == nil {
+		t.Skipf("set ETCD_ADDR to run TestNewEtcdDriver_Watch")
+	}
 	d := NewEtcdDriver(etcdClient, WithPrefix(prefix))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -85,3 +97,93 @@ func TestNewEtcdDriver_Watch(t *testing.T) {
 	}
 	cancel()
 }
+
+func TestEtcdDriver_Compaction(t *testing.T) {
+	if etcdClient == nil {
+		t.Skipf("set ETCD_ADDR to run TestEtcdDriver_Compaction")
+	}
+	p := prefix + "/compact/"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func() {
+		_, _ = etcdClient.Delete(context.Background(), p, clientv3.WithPrefix())
+	}()
+
+	_, _ = etcdClient.Put(ctx, p+"a", "a")
+	_, _ = etcdClient.Put(ctx, p+"b", "b")
+
+	d := NewEtcdDriver(etcdClient, WithPrefix(p), WithLogger(log.NewNopLogger()))
+	kvs, err := d.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, kvs, 2)
+
+	// changes happened while the driver is not watching, then compacted
+	_, _ = etcdClient.Delete(ctx, p+"a")
+	_, _ = etcdClient.Put(ctx, p+"b", "bb")
+	_, _ = etcdClient.Put(ctx, p+"c", "c")
+	resp, err := etcdClient.Put(ctx, p+"c", "cc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := etcdClient.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := d.Watch(ctx)
+	var got []*rule.KeyValue
+	for i := 0; i < 3; i++ {
+		select {
+		case kv := <-ch:
+			got = append(got, kv)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for resync")
+		}
+	}
+	assert.Equal(t, p+"b", got[0].Key)
+	assert.Equal(t, []byte("bb"), got[0].Value)
+	assert.Equal(t, p+"c", got[1].Key)
+	assert.Equal(t, []byte("cc"), got[1].Value)
+	assert.Equal(t, p+"a", got[2].Key)
+	assert.Equal(t, rule.EventTypeDelete, got[2].Type)
+	assert.Eventually(t, func() bool {
+		return d.Revision() >= resp.Header.Revision
+	}, time.Second, 10*time.Millisecond)
+
+	// keeps watching from the new revision
+	_, _ = etcdClient.Put(ctx, p+"d", "d")
+	kv := <-ch
+	assert.Equal(t, p+"d", kv.Key)
+}
+
+func TestDiff(t *testing.T) {
+	known := map[string]int64{"a": 1, "b": 2, "c": 3}
+	kvs := []*rule.KeyValue{
+		{Key: "a", Value: []byte("a"), Revision: 1},
+		{Key: "b", Value: []byte("bb"), Revision: 5},
+		{Key: "d", Value: []byte("d"), Revision: 6},
+	}
+	assert.Equal(t, []*rule.KeyValue{
+		{Key: "b", Value: []byte("bb"), Revision: 5},
+		{Key: "d", Value: []byte("d"), Revision: 6},
+		{Key: "c", Type: rule.EventTypeDelete, Revision: 7},
+	}, diff(known, kvs, 7))
+}
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{min: 100 * time.Millisecond, max: time.Second}
+	assert.Equal(t, 100*time.Millisecond, b.next())
+	assert.Equal(t, 200*time.Millisecond, b.next())
+
+	// a flapping watch keeps the backoff growing
+	b.watched(10 * time.Millisecond)
+	assert.Equal(t, 400*time.Millisecond, b.next())
+	assert.Equal(t, 800*time.Millisecond, b.next())
+	assert.Equal(t, time.Second, b.next())
+	assert.Equal(t, time.Second, b.next())
+
+	// a stable watch resets it
+	b.watched(time.Second)
+	assert.Equal(t, 100*time.Millisecond, b.next())
+}
@@ -0,0 +1,284 @@
+package driver
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/GGXXLL/rule"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// FileDriver loads rules from a local directory tree, one yaml file per rule.
+// The key of a rule is its path relative to the directory, without the file
+// extension and with a leading slash, eg. foo/bar.yaml becomes /foo/bar. A
+// file truncated to empty is an empty value, which the repository takes as
+// deleted, like an empty value in etcd.
+type FileDriver struct {
+	Options
+	dir string
+
+	ch chan *rule.KeyValue
+
+	once sync.Once
+	// the watcher is started by the first All or Watch, before the files are
+	// read, so that no change in between is lost. Its events are delivered
+	// once Watch is called.
+	listenOnce sync.Once
+	watcher    *fsnotify.Watcher
+	listenErr  error
+
+	mu sync.Mutex
+	// files records the keys that have been read or emitted, so that
+	// removing a directory can emit a delete event for each rule inside it.
+	files map[string]struct{}
+}
+
+func NewFileDriver(dir string, opts ...Option) *FileDriver {
+	d := &FileDriver{
+		Options: defaultOptions(),
+		dir:     filepath.Clean(dir),
+		ch:      make(chan *rule.KeyValue),
+		files:   make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(&d.Options)
+	}
+	return d
+}
+
+// One returns the content of the rule file, or nil if the file does not exist
+// or the key does not conform to the options like in All. A key outside of the
+// directory is an error.
+func (r *FileDriver) One(ctx context.Context, key string) ([]byte, error) {
+	for _, ext := range []string{".yaml", ".yml"} {
+		path := filepath.Join(r.dir, filepath.FromSlash(key)+ext)
+		rel, err := filepath.Rel(r.dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, errors.Errorf("key %s is outside of %s", key, r.dir)
+		}
+		if _, ok := r.key(path); !ok {
+			return nil, nil
+		}
+		b, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	return nil, nil
+}
+
+// All returns all kvs that conform to the specified prefix and regular
+// expression. The changes made since are delivered by Watch.
+func (r *FileDriver) All(ctx context.Context) ([]*rule.KeyValue, error) {
+	// a watcher failing to start is reported by Watch
+	_ = r.listen()
+	kvs := make([]*rule.KeyValue, 0)
+	err := filepath.WalkDir(r.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		key, ok := r.key(path)
+		if !ok {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.files[key] = struct{}{}
+		r.mu.Unlock()
+		kvs = append(kvs, &rule.KeyValue{Key: key, Value: b})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read directory %s", r.dir)
+	}
+	return kvs, nil
+}
+
+func (r *FileDriver) Watch(ctx context.Context) rule.KvWatchChan {
+	r.once.Do(func() {
+		go r.watch(ctx)
+	})
+	return r.ch
+}
+
+// listen starts the watcher on the directory tree once.
+func (r *FileDriver) listen() error {
+	r.listenOnce.Do(func() {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			r.listenErr = err
+			return
+		}
+		if err := r.add(context.Background(), watcher, r.dir, false); err != nil {
+			watcher.Close()
+			r.listenErr = err
+			return
+		}
+		r.watcher = watcher
+	})
+	return r.listenErr
+}
+
+func (r *FileDriver) watch(ctx context.Context) {
+	defer close(r.ch)
+
+	if err := r.listen(); err != nil {
+		r.send(ctx, &rule.KeyValue{Err: err})
+		return
+	}
+	watcher := r.watcher
+	defer watcher.Close()
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if err := r.handle(ctx, watcher, ev); err != nil {
+				r.send(ctx, &rule.KeyValue{Err: err})
+				return
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			r.send(ctx, &rule.KeyValue{Err: err})
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *FileDriver) handle(ctx context.Context, watcher *fsnotify.Watcher, ev fsnotify.Event) error {
+	switch {
+	case ev.Op&fsnotify.Create == fsnotify.Create:
+		info, err := os.Stat(ev.Name)
+		if err != nil {
+			// already gone, a following remove event will clean it up.
+			return nil
+		}
+		if info.IsDir() {
+			// a directory moved in or created, its rules are new as well.
+			return r.add(ctx, watcher, ev.Name, true)
+		}
+		r.update(ctx, ev.Name)
+	case ev.Op&fsnotify.Write == fsnotify.Write:
+		r.update(ctx, ev.Name)
+	case ev.Op&fsnotify.Remove == fsnotify.Remove, ev.Op&fsnotify.Rename == fsnotify.Rename:
+		r.remove(ctx, ev.Name)
+	}
+	return nil
+}
+
+// add watches the directory and its subdirectories. If emit is true, an
+// update event is sent for every rule file found.
+func (r *FileDriver) add(ctx context.Context, watcher *fsnotify.Watcher, dir string, emit bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		if emit {
+			r.update(ctx, path)
+		} else if key, ok := r.key(path); ok {
+			r.mu.Lock()
+			r.files[key] = struct{}{}
+			r.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+func (r *FileDriver) update(ctx context.Context, path string) {
+	key, ok := r.key(path)
+	if !ok {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// already gone, a following remove event will clean it up.
+		return
+	}
+	r.mu.Lock()
+	r.files[key] = struct{}{}
+	r.mu.Unlock()
+	r.send(ctx, &rule.KeyValue{Key: key, Value: b})
+}
+
+func (r *FileDriver) remove(ctx context.Context, path string) {
+	for _, key := range r.forget(path) {
+		r.send(ctx, &rule.KeyValue{Key: key, Type: rule.EventTypeDelete})
+	}
+}
+
+// forget removes the keys of the rule file, or of the rules inside the
+// directory, from the files recorded, and returns them.
+func (r *FileDriver) forget(path string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, ok := r.key(path); ok {
+		if _, ok := r.files[key]; ok {
+			delete(r.files, key)
+			return []string{key}
+		}
+		return nil
+	}
+	// the path may be a directory, remove all rules inside it.
+	rel, err := filepath.Rel(r.dir, path)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	dir := "/" + filepath.ToSlash(rel) + "/"
+	for key := range r.files {
+		if strings.HasPrefix(key, dir) {
+			delete(r.files, key)
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (r *FileDriver) send(ctx context.Context, kv *rule.KeyValue) {
+	select {
+	case r.ch <- kv:
+	case <-ctx.Done():
+	}
+}
+
+// key converts the file path to the rule key, returns false if the file is
+// not a rule or the key does not conform to the options.
+func (r *FileDriver) key(path string) (string, bool) {
+	ext := filepath.Ext(path)
+	if ext != ".yaml" && ext != ".yml" {
+		return "", false
+	}
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return "", false
+	}
+	rel, err := filepath.Rel(r.dir, path)
+	if err != nil {
+		return "", false
+	}
+	key := "/" + filepath.ToSlash(strings.TrimSuffix(rel, ext))
+	return key, r.match(key)
+}
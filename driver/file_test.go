@@ -0,0 +1,210 @@
+package driver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/GGXXLL/rule"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor reads events until one satisfies the predicate.
+func waitFor(t *testing.T, ch rule.KvWatchChan, f func(kv *rule.KeyValue) bool) *rule.KeyValue {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case kv, ok := <-ch:
+			if !ok {
+				t.Fatal("watch channel closed")
+			}
+			if kv.Err != nil {
+				t.Fatal(kv.Err)
+			}
+			if f(kv) {
+				return kv
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for event")
+		}
+	}
+}
+
+func TestFileDriver_One(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "foo", "bar.yml"), "foo")
+
+	d := NewFileDriver(dir)
+	v, err := d.One(context.Background(), "/foo/bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), v)
+
+	v, err = d.One(context.Background(), "/foo/baz")
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	v, err = d.One(context.Background(), "/foo/../foo/bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("foo"), v)
+
+	writeFile(t, filepath.Join(filepath.Dir(dir), "secret.yaml"), "secret")
+	v, err = d.One(context.Background(), "/../secret")
+	assert.Error(t, err)
+	assert.Nil(t, v)
+
+	d = NewFileDriver(dir, WithPrefix("/bar"))
+	v, err = d.One(context.Background(), "/foo/bar")
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	d = NewFileDriver(dir, WithRegex(regexp.MustCompile(`baz$`)))
+	v, err = d.One(context.Background(), "/foo/bar")
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+func TestFileDriver_All(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.yaml"), "a")
+	writeFile(t, filepath.Join(dir, "foo", "b.yml"), "b")
+	writeFile(t, filepath.Join(dir, "foo", "c.yaml"), "c")
+	writeFile(t, filepath.Join(dir, "foo", "d.json"), "d")
+	writeFile(t, filepath.Join(dir, "bar", "e.yaml"), "e")
+
+	cases := []struct {
+		name string
+		opts []Option
+		want map[string]string
+	}{
+		{
+			"all",
+			nil,
+			map[string]string{"/a": "a", "/foo/b": "b", "/foo/c": "c", "/bar/e": "e"},
+		},
+		{
+			"prefix",
+			[]Option{WithPrefix("/foo")},
+			map[string]string{"/foo/b": "b", "/foo/c": "c"},
+		},
+		{
+			"regex",
+			[]Option{WithPrefix("/foo"), WithRegex(regexp.MustCompile("c$"))},
+			map[string]string{"/foo/c": "c"},
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			kvs, err := NewFileDriver(dir, c.opts...).All(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, kv := range kvs {
+				got[kv.Key] = string(kv.Value)
+			}
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestFileDriver_Watch(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "foo", "a.yaml"), "a")
+
+	d := NewFileDriver(dir, WithPrefix("/foo"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := d.Watch(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// create
+	writeFile(t, filepath.Join(dir, "foo", "b.yaml"), "b")
+	kv := waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/foo/b" })
+	assert.Equal(t, rule.EventTypeUpdate, kv.Type)
+	assert.Equal(t, []byte("b"), kv.Value)
+
+	// edit
+	writeFile(t, filepath.Join(dir, "foo", "a.yaml"), "aa")
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/foo/a" })
+	assert.Equal(t, []byte("aa"), kv.Value)
+
+	// rename
+	if err := os.Rename(filepath.Join(dir, "foo", "b.yaml"), filepath.Join(dir, "foo", "c.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/foo/b" })
+	assert.Equal(t, rule.EventTypeDelete, kv.Type)
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/foo/c" })
+	assert.Equal(t, rule.EventTypeUpdate, kv.Type)
+	assert.Equal(t, []byte("b"), kv.Value)
+
+	// new directory
+	writeFile(t, filepath.Join(dir, "foo", "sub", "d.yaml"), "d")
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/foo/sub/d" })
+	assert.Equal(t, []byte("d"), kv.Value)
+
+	// delete
+	if err := os.Remove(filepath.Join(dir, "foo", "a.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/foo/a" })
+	assert.Equal(t, rule.EventTypeDelete, kv.Type)
+
+	// delete directory
+	if err := os.RemoveAll(filepath.Join(dir, "foo", "sub")); err != nil {
+		t.Fatal(err)
+	}
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/foo/sub/d" })
+	assert.Equal(t, rule.EventTypeDelete, kv.Type)
+
+	// filtered by prefix
+	writeFile(t, filepath.Join(dir, "bar", "e.yaml"), "e")
+	writeFile(t, filepath.Join(dir, "foo", "f.yaml"), "f")
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Type == rule.EventTypeUpdate })
+	assert.Equal(t, "/foo/f", kv.Key)
+}
+
+func TestFileDriver_ChangedAfterAll(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.yaml"), "a")
+
+	d := NewFileDriver(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kvs, err := d.All(ctx)
+	if !assert.NoError(t, err) || !assert.Len(t, kvs, 1) {
+		return
+	}
+	// changed between All and Watch
+	writeFile(t, filepath.Join(dir, "a.yaml"), "aa")
+	writeFile(t, filepath.Join(dir, "b.yaml"), "b")
+
+	ch := d.Watch(ctx)
+	kv := waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/a" && string(kv.Value) == "aa" })
+	assert.Equal(t, rule.EventTypeUpdate, kv.Type)
+	waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/b" })
+
+	// truncated to empty
+	if err := os.Truncate(filepath.Join(dir, "a.yaml"), 0); err != nil {
+		t.Fatal(err)
+	}
+	kv = waitFor(t, ch, func(kv *rule.KeyValue) bool { return kv.Key == "/a" && len(kv.Value) == 0 })
+	assert.Equal(t, rule.EventTypeUpdate, kv.Type)
+}
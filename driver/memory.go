@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/GGXXLL/rule"
+)
+
+// MemoryDriver keeps rules in memory. Rules are changed by Put and Delete,
+// the changes are delivered in order through Watch like the etcd events. It
+// is useful for tests and for embedding rules in the binary.
+type MemoryDriver struct {
+	Options
+	rwLock sync.RWMutex
+	kvs    map[string]*rule.KeyValue
+	// rev increases on every change, like the etcd revision.
+	rev int64
+	// pending holds the changes made after the last All, they are delivered
+	// once Watch is called. The changes are queued only from the first All or
+	// Watch until the watch ends, when there is someone to deliver them to.
+	pending  []*rule.KeyValue
+	queueing bool
+	watching bool
+	stopped  bool
+	notify   chan struct{}
+
+	ch   chan *rule.KeyValue
+	once sync.Once
+}
+
+func NewMemoryDriver(opts ...Option) *MemoryDriver {
+	d := &MemoryDriver{
+		Options: defaultOptions(),
+		kvs:     make(map[string]*rule.KeyValue),
+		notify:  make(chan struct{}, 1),
+		ch:      make(chan *rule.KeyValue),
+	}
+	for _, opt := range opts {
+		opt(&d.Options)
+	}
+	return d
+}
+
+// Put sets the value of the key.
+func (r *MemoryDriver) Put(key string, value string) {
+	r.rwLock.Lock()
+	r.rev++
+	kv := &rule.KeyValue{Key: key, Value: []byte(value), Revision: r.rev}
+	r.kvs[key] = kv
+	r.enqueue(kv)
+	r.rwLock.Unlock()
+}
+
+// Delete removes the key.
+func (r *MemoryDriver) Delete(key string) {
+	r.rwLock.Lock()
+	r.rev++
+	delete(r.kvs, key)
+	r.enqueue(&rule.KeyValue{Key: key, Type: rule.EventTypeDelete, Revision: r.rev})
+	r.rwLock.Unlock()
+}
+
+func (r *MemoryDriver) One(ctx context.Context, key string) ([]byte, error) {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	if kv, ok := r.kvs[key]; ok {
+		return kv.Value, nil
+	}
+	return nil, nil
+}
+
+// All returns all kvs that conform to the specified prefix and regular expression.
+func (r *MemoryDriver) All(ctx context.Context) ([]*rule.KeyValue, error) {
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+	kvs := make([]*rule.KeyValue, 0, len(r.kvs))
+	for k, kv := range r.kvs {
+		if !r.match(k) {
+			continue
+		}
+		kvs = append(kvs, &rule.KeyValue{Key: k, Value: kv.Value, Revision: kv.Revision})
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	// the snapshot already contains the pending changes.
+	if !r.watching {
+		r.pending = nil
+		r.queueing = !r.stopped
+	}
+	return kvs, nil
+}
+
+func (r *MemoryDriver) Watch(ctx context.Context) rule.KvWatchChan {
+	r.once.Do(func() {
+		r.rwLock.Lock()
+		r.watching = true
+		r.queueing = true
+		r.rwLock.Unlock()
+		go r.watch(ctx)
+	})
+	return r.ch
+}
+
+func (r *MemoryDriver) watch(ctx context.Context) {
+	defer close(r.ch)
+	defer func() {
+		r.rwLock.Lock()
+		r.pending = nil
+		r.queueing = false
+		r.stopped = true
+		r.rwLock.Unlock()
+	}()
+	for {
+		r.rwLock.Lock()
+		pending := r.pending
+		r.pending = nil
+		r.rwLock.Unlock()
+
+		for _, kv := range pending {
+			select {
+			case r.ch <- kv:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-r.notify:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enqueue must be called with the lock held.
+func (r *MemoryDriver) enqueue(kv *rule.KeyValue) {
+	if !r.queueing || !r.match(kv.Key) {
+		return
+	}
+	r.pending = append(r.pending, kv)
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/GGXXLL/rule"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryDriver(t *testing.T) {
+	d := NewMemoryDriver(WithPrefix("/foo"), WithRegex(regexp.MustCompile("[ab]$")))
+	d.Put("/foo/a", "a")
+	d.Put("/foo/c", "c")
+	d.Put("/bar/b", "b")
+	// nothing is queued before the first All or Watch
+	assert.Empty(t, d.pending)
+
+	v, err := d.One(context.Background(), "/foo/a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), v)
+
+	kvs, err := d.All(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []*rule.KeyValue{{Key: "/foo/a", Value: []byte("a"), Revision: 1}}, kvs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := d.Watch(ctx)
+
+	go func() {
+		d.Put("/foo/c", "c")
+		d.Put("/foo/b", "b")
+		d.Delete("/foo/a")
+	}()
+
+	kv := <-ch
+	assert.Equal(t, &rule.KeyValue{Key: "/foo/b", Value: []byte("b"), Revision: 5}, kv)
+	kv = <-ch
+	assert.Equal(t, &rule.KeyValue{Key: "/foo/a", Type: rule.EventTypeDelete, Revision: 6}, kv)
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	// put after the watch is stopped does not block nor queue
+	d.Put("/foo/b", "bb")
+	v, _ = d.One(context.Background(), "/foo/b")
+	assert.Equal(t, []byte("bb"), v)
+	_, err = d.All(context.Background())
+	assert.NoError(t, err)
+	d.Put("/foo/b", "bbb")
+	d.rwLock.RLock()
+	assert.Empty(t, d.pending)
+	d.rwLock.RUnlock()
+}
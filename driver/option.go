@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// Options holds the settings shared by all drivers of this package, they
+// are set by the Option functions.
+type Options struct {
+	prefix string
+	regexp *regexp.Regexp
+	limit  int64
+	logger log.Logger
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func defaultOptions() Options {
+	return Options{
+		limit:      1000,
+		logger:     log.NewJSONLogger(os.Stdout),
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+}
+
+// Option sets the Options of a driver.
+type Option func(o *Options)
+
+// WithPrefix specifies the path prefix to listen.
+func WithPrefix(p string) Option {
+	return func(o *Options) {
+		o.prefix = p
+	}
+}
+
+// WithLimit set the clientv3.WithLimit.
+func WithLimit(limit int64) Option {
+	return func(o *Options) {
+		o.limit = limit
+	}
+}
+
+// WithRegex filter the path to listen by regexp.
+func WithRegex(regexp *regexp.Regexp) Option {
+	return func(o *Options) {
+		o.regexp = regexp
+	}
+}
+
+// WithLogger replace the log.Logger
+func WithLogger(logger log.Logger) Option {
+	return func(o *Options) {
+		o.logger = logger
+	}
+}
+
+// WithBackoff sets the minimum and maximum interval between two attempts to
+// recover a broken watch. The interval doubles after each attempt, until a
+// watch lasts the maximum interval.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *Options) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// match reports whether the key conforms to the specified prefix and regular expression.
+func (o *Options) match(key string) bool {
+	if !strings.HasPrefix(key, o.prefix) {
+		return false
+	}
+	if o.regexp != nil && !o.regexp.MatchString(key) {
+		return false
+	}
+	return true
+}
@@ -26,6 +26,10 @@ func (d *Decoder) Decode(payload interface{}, r *http.Request) error {
 		if err != nil {
 			return errors.Wrapf(err, "cannot read body of http request")
 		}
+		if m, ok := payload.(Payload); ok {
+			// unmarshal into the map in place
+			payload = &m
+		}
 		err = json.Unmarshal(buf, payload)
 		if err != nil {
 			return errors.Wrap(err, "cannot json unmarshal")
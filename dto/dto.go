@@ -4,15 +4,16 @@ import (
 	"encoding/json"
 	"time"
 
-	"github.com/spf13/cast"
+	"github.com/GGXXLL/rule/internal/function"
 )
 
 const (
-	DateFormat     = "2006-01-02"
-	DateTimeFormat = "2006-01-02 15:04:05"
+	DateFormat     = function.DateFormat
+	DateTimeFormat = function.DateTimeFormat
 )
 
-// Payload provide common query
+// Payload provide common query, its methods are the builtin functions of the
+// expressions, which are also available to other payloads.
 type Payload map[string]interface{}
 
 func (p Payload) String() string {
@@ -20,109 +21,130 @@ func (p Payload) String() string {
 	return string(b)
 }
 
-func (p Payload) Now() time.Time {
-	return time.Now()
+// clock returns the time functions at present, in the location named by the
+// tz field if any. It fails if tz is not a known location, like the time
+// functions of the expressions.
+func (p Payload) clock() (*function.Time, error) {
+	loc := time.Local
+	if tz, ok := p["tz"].(string); ok && tz != "" {
+		var err error
+		if loc, err = function.LoadLocation(tz); err != nil {
+			return nil, err
+		}
+	}
+	return function.NewTime(time.Now(), loc), nil
 }
 
-func (p Payload) Date(s string) time.Time {
-	date, err := time.ParseInLocation(DateFormat, s, time.Local)
+func (p Payload) Now() (time.Time, error) {
+	c, err := p.clock()
 	if err != nil {
-		panic(err)
+		return time.Time{}, err
 	}
-	return date
+	return c.Now(), nil
 }
 
-func (p Payload) DaysAgo(s string) int {
-	if s == "" {
-		return 0
+func (p Payload) Date(s string) (time.Time, error) {
+	c, err := p.clock()
+	if err != nil {
+		return time.Time{}, err
 	}
-	return int(time.Since(p.DateTime(s)).Hours() / 24)
+	return c.Date(s), nil
 }
 
-func (p Payload) HoursAgo(s string) int {
-	if s == "" {
-		return 0
+func (p Payload) DaysAgo(s string) (int, error) {
+	c, err := p.clock()
+	if err != nil {
+		return 0, err
 	}
-	return int(time.Since(p.DateTime(s)).Hours())
+	return c.DaysAgo(s), nil
 }
 
-func (p Payload) MinutesAgo(s string) int {
-	if s == "" {
-		return 0
+func (p Payload) HoursAgo(s string) (int, error) {
+	c, err := p.clock()
+	if err != nil {
+		return 0, err
 	}
-	return int(time.Since(p.DateTime(s)).Minutes())
+	return c.HoursAgo(s), nil
 }
 
-func (p Payload) DateTime(s string) time.Time {
-	date, err := time.ParseInLocation(DateTimeFormat, s, time.Local)
+func (p Payload) MinutesAgo(s string) (int, error) {
+	c, err := p.clock()
 	if err != nil {
-		panic(err)
-	}
-	return date
-}
-
-func (p Payload) IsBefore(s string) bool {
-	var (
-		t   time.Time
-		err error
-	)
-	if len(s) == 10 {
-		t, err = time.ParseInLocation(DateFormat, s, time.Local)
-	} else {
-		t, err = time.ParseInLocation(DateTimeFormat, s, time.Local)
+		return 0, err
 	}
+	return c.MinutesAgo(s), nil
+}
+
+func (p Payload) DateTime(s string) (time.Time, error) {
+	c, err := p.clock()
 	if err != nil {
-		panic(err)
+		return time.Time{}, err
 	}
-	return time.Now().Before(t)
-}
-
-func (p Payload) IsAfter(s string) bool {
-	var (
-		t   time.Time
-		err error
-	)
-	if len(s) == 10 {
-		t, err = time.ParseInLocation(DateFormat, s, time.Local)
-	} else {
-		t, err = time.ParseInLocation(DateTimeFormat, s, time.Local)
+	return c.DateTime(s), nil
+}
+
+func (p Payload) IsBefore(s string) (bool, error) {
+	c, err := p.clock()
+	if err != nil {
+		return false, err
 	}
+	return c.IsBefore(s), nil
+}
+
+func (p Payload) IsAfter(s string) (bool, error) {
+	c, err := p.clock()
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-	return time.Now().After(t)
+	return c.IsAfter(s), nil
 }
 
-func (p Payload) IsBetween(begin string, end string) bool {
-	return p.IsAfter(begin) && p.IsBefore(end)
+func (p Payload) IsBetween(begin string, end string) (bool, error) {
+	c, err := p.clock()
+	if err != nil {
+		return false, err
+	}
+	return c.IsBetween(begin, end), nil
 }
 
-func (p Payload) IsWeekday(day int) bool {
-	return time.Now().Weekday() == time.Weekday(day)
+func (p Payload) IsWeekday(day int) (bool, error) {
+	c, err := p.clock()
+	if err != nil {
+		return false, err
+	}
+	return c.IsWeekday(day), nil
 }
 
-func (p Payload) IsWeekend() bool {
-	if weekday := time.Now().Weekday(); weekday == 0 || weekday == 6 {
-		return true
+func (p Payload) IsWeekend() (bool, error) {
+	c, err := p.clock()
+	if err != nil {
+		return false, err
 	}
-	return false
+	return c.IsWeekend(), nil
 }
 
-func (p Payload) IsToday(s string) bool {
-	return time.Now().Format(DateFormat) == s
+func (p Payload) IsToday(s string) (bool, error) {
+	c, err := p.clock()
+	if err != nil {
+		return false, err
+	}
+	return c.IsToday(s), nil
 }
 
-func (p Payload) IsHourRange(begin int, end int) bool {
-	now := time.Now().Hour()
-	return now >= begin && now <= end
+func (p Payload) IsHourRange(begin int, end int) (bool, error) {
+	c, err := p.clock()
+	if err != nil {
+		return false, err
+	}
+	return c.IsHourRange(begin, end), nil
 }
 
 func (p Payload) ToString(str interface{}) string {
-	return cast.ToString(str)
+	return function.ToString(str)
 }
 
 func (p Payload) ToInt(int interface{}) int {
-	return cast.ToInt(int)
+	return function.ToInt(int)
 }
 
 type Data map[string]interface{}
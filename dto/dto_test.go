@@ -1,6 +1,9 @@
 package dto
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,7 +12,9 @@ import (
 
 func TestPayload_HoursAgo(t *testing.T) {
 	p := &Payload{}
-	assert.Equal(t, p.HoursAgo("2021-01-01 00:00:00"),
+	ago, err := p.HoursAgo("2021-01-01 00:00:00")
+	assert.NoError(t, err)
+	assert.Equal(t, ago,
 		int(time.Since(time.Date(
 			2021,
 			01,
@@ -24,7 +29,9 @@ func TestPayload_HoursAgo(t *testing.T) {
 
 func TestPayload_MinutesAgo(t *testing.T) {
 	p := &Payload{}
-	assert.Equal(t, p.MinutesAgo("2021-01-01 00:00:00"),
+	ago, err := p.MinutesAgo("2021-01-01 00:00:00")
+	assert.NoError(t, err)
+	assert.Equal(t, ago,
 		int(time.Since(time.Date(
 			2021,
 			01,
@@ -36,3 +43,25 @@ func TestPayload_MinutesAgo(t *testing.T) {
 			time.Local,
 		)).Minutes()))
 }
+
+func TestPayload_UnknownTimeZone(t *testing.T) {
+	p := Payload{"tz": "Mars/Olympus"}
+	_, err := p.IsToday("2021-01-01")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown time zone Mars/Olympus")
+	}
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	decoder := NewDecoder()
+
+	payload := make(Payload)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "foo", "age": 1}`))
+	assert.NoError(t, decoder.Decode(payload, r))
+	assert.Equal(t, Payload{"name": "foo", "age": float64(1)}, payload)
+
+	payload = make(Payload)
+	r = httptest.NewRequest(http.MethodGet, "/?name=foo&tag=a&tag=b", nil)
+	assert.NoError(t, decoder.Decode(payload, r))
+	assert.Equal(t, Payload{"name": "foo", "tag": []string{"a", "b"}}, payload)
+}
@@ -0,0 +1,79 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrRuleNotFound is returned when the rule is not loaded.
+	ErrRuleNotFound = errors.New("rule not found")
+	// ErrMissingKey is the kind of EvalError when the by key of a switch,
+	// range or rollout rule is missing in the payload.
+	ErrMissingKey = errors.New("missing key")
+	// ErrKeyType is the kind of EvalError when the value of the by key has an
+	// unsupported type, eg. a map for switch or a string for range.
+	ErrKeyType = errors.New("unsupported key type")
+	// ErrExpression is the kind of EvalError when an expression fails at runtime.
+	ErrExpression = errors.New("expression error")
+	// ErrReferenceCycle is returned when the rules referenced or extended by
+	// a rule lead back to itself, it is also the kind of EvalError if such a
+	// rule is evaluated.
+	ErrReferenceCycle = errors.New("reference cycle")
+	// ErrVersionNotFound is returned when rolling back to a version not in
+	// the history.
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+// EvalError is returned when a rule fails to calculate. errors.Is reports
+// whether the error is of Kind, errors.As and errors.Unwrap reach Err.
+type EvalError struct {
+	// Rule is the name of the rule, empty if the ruler is calculated directly.
+	Rule string
+	// Path locates the failing node in the rule document, same as Trace.Path.
+	Path string
+	// Condition is the failing expression, or the by key.
+	Condition string
+	// Kind is one of ErrMissingKey, ErrKeyType and ErrExpression, or
+	// ErrRuleNotFound if a referenced rule is missing, or ErrReferenceCycle
+	// if it leads back to the rule.
+	Kind error
+	Err  error
+}
+
+func (e *EvalError) Error() string {
+	msg := e.Kind.Error()
+	if e.Rule != "" {
+		msg = fmt.Sprintf("%s in %s", msg, e.Rule)
+	}
+	if e.Path != "" {
+		msg = fmt.Sprintf("%s at %s", msg, e.Path)
+	}
+	if e.Condition != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Condition)
+	}
+	return fmt.Sprintf("%s: %s", msg, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+func (e *EvalError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// DriverError is returned when the driver fails to load or watch the rules.
+type DriverError struct {
+	// Op is the failing operation, "all" or "watch".
+	Op  string
+	Err error
+}
+
+func (e *DriverError) Error() string {
+	return fmt.Sprintf("driver %s: %s", e.Op, e.Err)
+}
+
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
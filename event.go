@@ -5,4 +5,5 @@ type EventType int32
 const (
 	EventTypeUpdate EventType = 0
 	EventTypeDelete EventType = 1
+	EventTypeCreate EventType = 2
 )
@@ -0,0 +1,110 @@
+package grpcserver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/contract"
+	"github.com/GGXXLL/rule/grpcserver/pb"
+	"github.com/GGXXLL/rule/repository"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Broadcaster is a contract.Dispatcher that fans the repository events out to
+// the WatchRule streams. Pass it to both repository.WithDispatcher and
+// WithBroadcaster. Listeners subscribed to it still receive the events.
+type Broadcaster struct {
+	mu        sync.Mutex
+	watchers  map[string]map[*watcher]struct{}
+	listeners []contract.Listener
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{watchers: make(map[string]map[*watcher]struct{})}
+}
+
+// Dispatch sends the event to the watchers of the rule and the listeners of the topic.
+func (b *Broadcaster) Dispatch(ctx context.Context, topic interface{}, payload interface{}) error {
+	b.mu.Lock()
+	listeners := b.listeners
+	if c, ok := payload.(repository.Container); ok && c.KV != nil {
+		eventType, _ := topic.(rule.EventType)
+		ev := &pb.RuleEvent{
+			Name:     c.KV.Key,
+			Type:     pb.EventType(eventType),
+			Content:  c.KV.Value,
+			Revision: c.KV.Revision,
+		}
+		if eventType == rule.EventTypeDelete {
+			ev.Content = nil
+		}
+		for w := range b.watchers[c.KV.Key] {
+			w.push(ev)
+		}
+	}
+	b.mu.Unlock()
+
+	var err multierror.Error
+	for _, l := range listeners {
+		if l.Listen() != topic {
+			continue
+		}
+		if e := l.Process(ctx, payload); e != nil {
+			err.Errors = append(err.Errors, e)
+		}
+	}
+	return err.ErrorOrNil()
+}
+
+func (b *Broadcaster) Subscribe(listener contract.Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+func (b *Broadcaster) watch(name string) *watcher {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := &watcher{notify: make(chan struct{}, 1)}
+	if b.watchers[name] == nil {
+		b.watchers[name] = make(map[*watcher]struct{})
+	}
+	b.watchers[name][w] = struct{}{}
+	return w
+}
+
+func (b *Broadcaster) unwatch(name string, w *watcher) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.watchers[name], w)
+	if len(b.watchers[name]) == 0 {
+		delete(b.watchers, name)
+	}
+}
+
+// watcher keeps the latest event only, a slow stream skips the intermediate
+// versions instead of blocking the repository.
+type watcher struct {
+	mu      sync.Mutex
+	pending *pb.RuleEvent
+	notify  chan struct{}
+}
+
+func (w *watcher) push(ev *pb.RuleEvent) {
+	w.mu.Lock()
+	w.pending = ev
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) pop() *pb.RuleEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ev := w.pending
+	w.pending = nil
+	return ev
+}
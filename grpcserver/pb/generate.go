@@ -0,0 +1,5 @@
+// Package pb contains the protobuf definition of the rule gRPC service and
+// its generated code.
+package pb
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative grpcserver/pb/rule.proto
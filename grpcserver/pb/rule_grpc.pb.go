@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.5
+// source: grpcserver/pb/rule.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// RuleServiceClient is the client API for RuleService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RuleServiceClient interface {
+	// Evaluate calculates the named rule with the payload.
+	Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*EvaluateResponse, error)
+	// BatchEvaluate calculates several rules at once, each result carries its own error.
+	BatchEvaluate(ctx context.Context, in *BatchEvaluateRequest, opts ...grpc.CallOption) (*BatchEvaluateResponse, error)
+	// WatchRule sends the current content of the named rule if it exists, then
+	// every change of it.
+	WatchRule(ctx context.Context, in *WatchRuleRequest, opts ...grpc.CallOption) (RuleService_WatchRuleClient, error)
+}
+
+type ruleServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRuleServiceClient(cc grpc.ClientConnInterface) RuleServiceClient {
+	return &ruleServiceClient{cc}
+}
+
+func (c *ruleServiceClient) Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*EvaluateResponse, error) {
+	out := new(EvaluateResponse)
+	err := c.cc.Invoke(ctx, "/rule.v1.RuleService/Evaluate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ruleServiceClient) BatchEvaluate(ctx context.Context, in *BatchEvaluateRequest, opts ...grpc.CallOption) (*BatchEvaluateResponse, error) {
+	out := new(BatchEvaluateResponse)
+	err := c.cc.Invoke(ctx, "/rule.v1.RuleService/BatchEvaluate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ruleServiceClient) WatchRule(ctx context.Context, in *WatchRuleRequest, opts ...grpc.CallOption) (RuleService_WatchRuleClient, error) {
+	stream, err := c.cc.NewStream(ctx, &RuleService_ServiceDesc.Streams[0], "/rule.v1.RuleService/WatchRule", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ruleServiceWatchRuleClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RuleService_WatchRuleClient interface {
+	Recv() (*RuleEvent, error)
+	grpc.ClientStream
+}
+
+type ruleServiceWatchRuleClient struct {
+	grpc.ClientStream
+}
+
+func (x *ruleServiceWatchRuleClient) Recv() (*RuleEvent, error) {
+	m := new(RuleEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RuleServiceServer is the server API for RuleService service.
+// All implementations must embed UnimplementedRuleServiceServer
+// for forward compatibility
+type RuleServiceServer interface {
+	// Evaluate calculates the named rule with the payload.
+	Evaluate(context.Context, *EvaluateRequest) (*EvaluateResponse, error)
+	// BatchEvaluate calculates several rules at once, each result carries its own error.
+	BatchEvaluate(context.Context, *BatchEvaluateRequest) (*BatchEvaluateResponse, error)
+	// WatchRule sends the current content of the named rule if it exists, then
+	// every change of it.
+	WatchRule(*WatchRuleRequest, RuleService_WatchRuleServer) error
+	mustEmbedUnimplementedRuleServiceServer()
+}
+
+// UnimplementedRuleServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedRuleServiceServer struct {
+}
+
+func (UnimplementedRuleServiceServer) Evaluate(context.Context, *EvaluateRequest) (*EvaluateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Evaluate not implemented")
+}
+func (UnimplementedRuleServiceServer) BatchEvaluate(context.Context, *BatchEvaluateRequest) (*BatchEvaluateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchEvaluate not implemented")
+}
+func (UnimplementedRuleServiceServer) WatchRule(*WatchRuleRequest, RuleService_WatchRuleServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchRule not implemented")
+}
+func (UnimplementedRuleServiceServer) mustEmbedUnimplementedRuleServiceServer() {}
+
+// UnsafeRuleServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RuleServiceServer will
+// result in compilation errors.
+type UnsafeRuleServiceServer interface {
+	mustEmbedUnimplementedRuleServiceServer()
+}
+
+func RegisterRuleServiceServer(s grpc.ServiceRegistrar, srv RuleServiceServer) {
+	s.RegisterService(&RuleService_ServiceDesc, srv)
+}
+
+func _RuleService_Evaluate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EvaluateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuleServiceServer).Evaluate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rule.v1.RuleService/Evaluate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuleServiceServer).Evaluate(ctx, req.(*EvaluateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RuleService_BatchEvaluate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchEvaluateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RuleServiceServer).BatchEvaluate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rule.v1.RuleService/BatchEvaluate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RuleServiceServer).BatchEvaluate(ctx, req.(*BatchEvaluateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RuleService_WatchRule_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRuleRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RuleServiceServer).WatchRule(m, &ruleServiceWatchRuleServer{stream})
+}
+
+type RuleService_WatchRuleServer interface {
+	Send(*RuleEvent) error
+	grpc.ServerStream
+}
+
+type ruleServiceWatchRuleServer struct {
+	grpc.ServerStream
+}
+
+func (x *ruleServiceWatchRuleServer) Send(m *RuleEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// RuleService_ServiceDesc is the grpc.ServiceDesc for RuleService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RuleService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rule.v1.RuleService",
+	HandlerType: (*RuleServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Evaluate",
+			Handler:    _RuleService_Evaluate_Handler,
+		},
+		{
+			MethodName: "BatchEvaluate",
+			Handler:    _RuleService_BatchEvaluate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRule",
+			Handler:       _RuleService_WatchRule_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "grpcserver/pb/rule.proto",
+}
@@ -0,0 +1,145 @@
+// Package grpcserver implements the gRPC RuleService defined in pb, which
+// evaluates the rules of a repository and pushes their changes.
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/grpcserver/pb"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type Option func(s *Server)
+
+// WithBroadcaster enables WatchRule, the broadcaster must be the dispatcher
+// of the repository.
+func WithBroadcaster(b *Broadcaster) Option {
+	return func(s *Server) {
+		s.broadcaster = b
+	}
+}
+
+// WithLogger replace the log.Logger
+func WithLogger(logger log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// Server implements pb.RuleServiceServer.
+type Server struct {
+	pb.UnimplementedRuleServiceServer
+	repository  rule.Repository
+	broadcaster *Broadcaster
+	logger      log.Logger
+}
+
+// NewServer returns the Server evaluating the rules of the repository, register
+// it with pb.RegisterRuleServiceServer.
+func NewServer(repository rule.Repository, opts ...Option) *Server {
+	s := &Server{
+		repository: repository,
+		logger:     log.NewJSONLogger(os.Stdout),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Server) Evaluate(ctx context.Context, req *pb.EvaluateRequest) (*pb.EvaluateResponse, error) {
+	data, err := s.evaluate(req)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.EvaluateResponse{Data: data}, nil
+}
+
+func (s *Server) BatchEvaluate(ctx context.Context, req *pb.BatchEvaluateRequest) (*pb.BatchEvaluateResponse, error) {
+	resp := &pb.BatchEvaluateResponse{Results: make([]*pb.EvaluateResult, 0, len(req.Requests))}
+	for _, r := range req.Requests {
+		result := &pb.EvaluateResult{Name: r.Name}
+		data, err := s.evaluate(r)
+		if err != nil {
+			st := status.Convert(err)
+			result.Code = uint32(st.Code())
+			result.Error = st.Message()
+		} else {
+			result.Data = data
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
+
+func (s *Server) evaluate(req *pb.EvaluateRequest) (*structpb.Struct, error) {
+	ruler := s.repository.GetRuler(req.Name)
+	if ruler == nil {
+		return nil, status.Errorf(codes.NotFound, "%s: %s", rule.ErrRuleNotFound, req.Name)
+	}
+	payload := dto.Payload(req.Payload.AsMap())
+	data, err := rule.Calculate(ruler, payload)
+	if err != nil {
+		_ = level.Warn(s.logger).Log("msg", fmt.Sprintf("%s calculate error", req.Name), "err", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	result, err := structpb.NewStruct(data)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot convert the result of %s: %s", req.Name, err)
+	}
+	return result, nil
+}
+
+// WatchRule sends the current content of the rule as a create event if it
+// exists, then its changes until the client cancels. The revision of the
+// create event is 0 unless the repository is a rule.StatusRepository.
+func (s *Server) WatchRule(req *pb.WatchRuleRequest, stream pb.RuleService_WatchRuleServer) error {
+	if s.broadcaster == nil {
+		return status.Error(codes.Unimplemented, "WatchRule requires a broadcaster")
+	}
+	// watch before reading the current content so that no change is missed
+	w := s.broadcaster.watch(req.Name)
+	defer s.broadcaster.unwatch(req.Name, w)
+
+	if raw := s.repository.GetRaw(req.Name); raw != nil {
+		// the revision of the rule itself rather than that of the repository,
+		// a change in between is sent afterwards anyway.
+		var revision int64
+		var repo rule.StatusRepository
+		if rule.AsRepository(s.repository, &repo) {
+			st, _ := repo.Status(req.Name)
+			revision = st.Revision
+		}
+		err := stream.Send(&pb.RuleEvent{
+			Name:     req.Name,
+			Type:     pb.EventType_EVENT_TYPE_CREATE,
+			Content:  raw,
+			Revision: revision,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-w.notify:
+			ev := w.pop()
+			if ev == nil {
+				continue
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/GGXXLL/rule/driver"
+	"github.com/GGXXLL/rule/grpcserver/pb"
+	"github.com/GGXXLL/rule/repository"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const fooRule = `
+style: advanced
+rule:
+  - if: name == "foo"
+    then:
+      age: 1
+  - if: age > 10
+    then:
+      age: 2
+`
+
+func newClient(t *testing.T) (pb.RuleServiceClient, *driver.MemoryDriver) {
+	t.Helper()
+	drv := driver.NewMemoryDriver()
+	drv.Put("/example/foo", fooRule)
+	broadcaster := NewBroadcaster()
+	repo, err := repository.NewRepository(drv,
+		repository.WithLogger(log.NewNopLogger()),
+		repository.WithDispatcher(broadcaster),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterRuleServiceServer(server, NewServer(repo, WithBroadcaster(broadcaster), WithLogger(log.NewNopLogger())))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return pb.NewRuleServiceClient(conn), drv
+}
+
+func payload(t *testing.T, m map[string]interface{}) *structpb.Struct {
+	t.Helper()
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServer_Evaluate(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	resp, err := client.Evaluate(ctx, &pb.EvaluateRequest{
+		Name:    "/example/foo",
+		Payload: payload(t, map[string]interface{}{"name": "bar", "age": 11}),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"age": float64(2)}, resp.Data.AsMap())
+
+	_, err = client.Evaluate(ctx, &pb.EvaluateRequest{Name: "/example/bar"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Evaluate(ctx, &pb.EvaluateRequest{
+		Name:    "/example/foo",
+		Payload: payload(t, map[string]interface{}{"name": "bar", "age": "11"}),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_BatchEvaluate(t *testing.T) {
+	client, _ := newClient(t)
+
+	resp, err := client.BatchEvaluate(context.Background(), &pb.BatchEvaluateRequest{
+		Requests: []*pb.EvaluateRequest{
+			{Name: "/example/foo", Payload: payload(t, map[string]interface{}{"name": "foo"})},
+			{Name: "/example/bar"},
+		},
+	})
+	if !assert.NoError(t, err) || !assert.Len(t, resp.Results, 2) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"age": float64(1)}, resp.Results[0].Data.AsMap())
+	assert.Equal(t, uint32(codes.OK), resp.Results[0].Code)
+	assert.Equal(t, "/example/bar", resp.Results[1].Name)
+	assert.Equal(t, uint32(codes.NotFound), resp.Results[1].Code)
+	assert.NotEmpty(t, resp.Results[1].Error)
+}
+
+func TestServer_WatchRule(t *testing.T) {
+	client, drv := newClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the repository moves past the revision of the rule
+	drv.Put("/example/baz", "style: basic\nrule:\n  age: 0\n")
+	assert.Eventually(t, func() bool {
+		_, err := client.Evaluate(ctx, &pb.EvaluateRequest{Name: "/example/baz"})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stream, err := client.WatchRule(ctx, &pb.WatchRuleRequest{Name: "/example/foo"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	ev, err := stream.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, pb.EventType_EVENT_TYPE_CREATE, ev.Type)
+	assert.Equal(t, fooRule, string(ev.Content))
+	assert.Equal(t, int64(1), ev.Revision)
+
+	updated := "style: basic\nrule:\n  age: 3\n"
+	drv.Put("/example/foo", updated)
+	ev, err = stream.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, pb.EventType_EVENT_TYPE_UPDATE, ev.Type)
+	assert.Equal(t, updated, string(ev.Content))
+	assert.Equal(t, int64(3), ev.Revision)
+
+	// changes of other rules are not sent
+	drv.Put("/example/bar", updated)
+	drv.Delete("/example/foo")
+	ev, err = stream.Recv()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "/example/foo", ev.Name)
+	assert.Equal(t, pb.EventType_EVENT_TYPE_DELETE, ev.Type)
+	assert.Empty(t, ev.Content)
+}
+
+func TestServer_WatchRuleWithoutBroadcaster(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	repo, err := repository.NewRepository(drv, repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewServer(repo).WatchRule(&pb.WatchRuleRequest{Name: "foo"}, nil)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
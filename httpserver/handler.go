@@ -0,0 +1,191 @@
+// Package httpserver exposes the rules of a repository over HTTP, so that
+// services not written in Go can share the same results.
+//
+//	GET  /rules                  lists the names of the loaded rules
+//	GET  /rules/{name}?k=v       evaluates the rule with the query as payload
+//	POST /rules/{name}           evaluates the rule with the json body as payload
+//	GET  /status                 lists the load status of the rules, see rule.Status
+//
+// Listing the rules requires a rule.ListRepository and their status a
+// rule.StatusRepository, the handler answers 501 otherwise.
+//
+// A rule named /example/foo is served at /rules/example/foo.
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	rulesPath  = "/rules"
+	statusPath = "/status"
+)
+
+// Decoder decodes the payload from the request, dto.Decoder is the default.
+type Decoder interface {
+	Decode(payload interface{}, r *http.Request) error
+}
+
+type Option func(h *handler)
+
+// WithDecoder replace the Decoder
+func WithDecoder(decoder Decoder) Option {
+	return func(h *handler) {
+		h.decoder = decoder
+	}
+}
+
+// WithLogger replace the log.Logger
+func WithLogger(logger log.Logger) Option {
+	return func(h *handler) {
+		h.logger = logger
+	}
+}
+
+type handler struct {
+	repository rule.Repository
+	decoder    Decoder
+	logger     log.Logger
+}
+
+// NewHandler returns the http.Handler serving the rules of the repository.
+func NewHandler(repository rule.Repository, opts ...Option) http.Handler {
+	h := &handler{
+		repository: repository,
+		decoder:    dto.NewDecoder(),
+		logger:     log.NewJSONLogger(os.Stdout),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+type listResponse struct {
+	Revision int64    `json:"revision"`
+	Rules    []string `json:"rules"`
+}
+
+type statusResponse struct {
+	Revision int64         `json:"revision"`
+	Rules    []rule.Status `json:"rules"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == rulesPath || r.URL.Path == rulesPath+"/":
+		if r.Method != http.MethodGet {
+			h.methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.list(w)
+	case strings.HasPrefix(r.URL.Path, rulesPath+"/"):
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			h.methodNotAllowed(w, http.MethodGet, http.MethodPost)
+			return
+		}
+		h.evaluate(w, r, strings.TrimPrefix(r.URL.Path, rulesPath))
+	case r.URL.Path == statusPath:
+		if r.Method != http.MethodGet {
+			h.methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.status(w)
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+func (h *handler) list(w http.ResponseWriter) {
+	var repo rule.ListRepository
+	if !rule.AsRepository(h.repository, &repo) {
+		h.notImplemented(w, "the repository does not list the rules")
+		return
+	}
+	writeJSON(w, http.StatusOK, listResponse{
+		Revision: h.revision(),
+		Rules:    repo.Names(),
+	})
+}
+
+func (h *handler) status(w http.ResponseWriter) {
+	var repo rule.StatusRepository
+	if !rule.AsRepository(h.repository, &repo) {
+		h.notImplemented(w, "the repository does not report the status of the rules")
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{
+		Revision: h.revision(),
+		Rules:    repo.Statuses(),
+	})
+}
+
+// revision returns the revision the repository has synced to, 0 unless it is
+// a rule.RevisionRepository.
+func (h *handler) revision() int64 {
+	var repo rule.RevisionRepository
+	if !rule.AsRepository(h.repository, &repo) {
+		return 0
+	}
+	return repo.Revision()
+}
+
+func (h *handler) evaluate(w http.ResponseWriter, r *http.Request, name string) {
+	ruler := h.ruler(name)
+	if ruler == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("%s: %s", rule.ErrRuleNotFound, name)})
+		return
+	}
+
+	payload := make(dto.Payload)
+	if err := h.decoder.Decode(payload, r); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	data, err := rule.Calculate(ruler, payload)
+	if err != nil {
+		_ = level.Warn(h.logger).Log("msg", fmt.Sprintf("%s calculate error", name), "err", err)
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// ruler looks up the rule by the name in the path, which has a leading
+// slash. Rules named without the leading slash are looked up as well.
+func (h *handler) ruler(name string) rule.Ruler {
+	if ruler := h.repository.GetRuler(name); ruler != nil {
+		return ruler
+	}
+	return h.repository.GetRuler(strings.TrimPrefix(name, "/"))
+}
+
+func (h *handler) methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+}
+
+// notImplemented reports that the repository lacks an optional interface.
+func (h *handler) notImplemented(w http.ResponseWriter, msg string) {
+	writeJSON(w, http.StatusNotImplemented, errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
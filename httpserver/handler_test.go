@@ -0,0 +1,142 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/driver"
+	"github.com/GGXXLL/rule/repository"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	drv := driver.NewMemoryDriver()
+	drv.Put("/example/foo", `
+style: advanced
+rule:
+  - if: name == "foo"
+    then:
+      age: 1
+  - if: age > 10
+    then:
+      age: 2
+  - if: true
+    then:
+      age: 3
+`)
+	drv.Put("bar", `
+style: basic
+rule:
+  age: 4
+`)
+	drv.Put("baz", `style: unknown`)
+	repo, err := repository.NewRepository(drv, repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewHandler(repo, WithLogger(log.NewNopLogger())))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandler(t *testing.T) {
+	server := newServer(t)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		expect string
+	}{
+		{"get", http.MethodGet, "/rules/example/foo?name=foo", "", http.StatusOK, `{"age": 1}`},
+		{"post fallback", http.MethodPost, "/rules/example/foo", `{"name": "bar", "age": 1}`, http.StatusOK, `{"age": 3}`},
+		{"post", http.MethodPost, "/rules/example/foo", `{"name": "bar", "age": 11}`, http.StatusOK, `{"age": 2}`},
+		{"without leading slash", http.MethodGet, "/rules/bar", "", http.StatusOK, `{"age": 4}`},
+		{"unknown rule", http.MethodGet, "/rules/example/baz", "", http.StatusNotFound, ""},
+		{"invalid body", http.MethodPost, "/rules/example/foo", `{`, http.StatusBadRequest, ""},
+		// comparing the string from the query with a number fails
+		{"evaluation error", http.MethodGet, "/rules/example/foo?name=bar&age=11", "", http.StatusUnprocessableEntity, ""},
+		{"method not allowed", http.MethodDelete, "/rules/example/foo", "", http.StatusMethodNotAllowed, ""},
+		{"not found", http.MethodGet, "/foo", "", http.StatusNotFound, ""},
+		{"status method not allowed", http.MethodPost, "/status", "", http.StatusMethodNotAllowed, ""},
+		{"list", http.MethodGet, "/rules", "", http.StatusOK, `{"revision": 3, "rules": ["/example/foo", "bar"]}`},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest(c.method, server.URL+c.path, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, c.status, resp.StatusCode)
+			assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			if c.expect != "" {
+				expect, _ := json.Marshal(body)
+				assert.JSONEq(t, c.expect, string(expect))
+				return
+			}
+			assert.NotEmpty(t, body["error"])
+		})
+	}
+}
+
+func TestHandler_Status(t *testing.T) {
+	server := newServer(t)
+
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body statusResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, int64(3), body.Revision)
+	if assert.Len(t, body.Rules, 3) {
+		assert.Equal(t, "/example/foo", body.Rules[0].Name)
+		assert.Equal(t, rule.LoadStateActive, body.Rules[0].State)
+		assert.Equal(t, int64(1), body.Rules[0].Revision)
+		assert.Len(t, body.Rules[0].Hash, 64)
+		assert.Equal(t, "baz", body.Rules[2].Name)
+		assert.Equal(t, rule.LoadStateRejected, body.Rules[2].State)
+		assert.Equal(t, "style: unknown", body.Rules[2].Rejected)
+		assert.NotEmpty(t, body.Rules[2].Error)
+	}
+}
+
+func TestHandler_NotImplemented(t *testing.T) {
+	repo, err := repository.NewRepository(driver.NewMemoryDriver(), repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hides the optional interfaces of the repository
+	server := httptest.NewServer(NewHandler(struct{ rule.Repository }{repo}, WithLogger(log.NewNopLogger())))
+	t.Cleanup(server.Close)
+
+	for _, path := range []string{"/rules", "/status"} {
+		resp, err := http.Get(server.URL + path)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	}
+}
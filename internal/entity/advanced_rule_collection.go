@@ -11,14 +11,31 @@ import (
 	"github.com/xeipuuv/gojsonschema"
 )
 
+const (
+	// modeFirst returns the data of the first matching item.
+	modeFirst = "first"
+	// modeMerge deep-merges the data of all matching items in order.
+	modeMerge = "merge"
+
+	listsReplace = "replace"
+	listsAppend  = "append"
+)
+
 type AdvancedRuleCollection struct {
 	style string
-	items []*AdvancedRuleItem
+	mode  string
+	// lists decides how lists are merged in merge mode.
+	lists string
+	// bindings are the let variables, available to the items and their children.
+	bindings []*binding
+	items    []*AdvancedRuleItem
 }
 
 func NewAdvancedRule() *AdvancedRuleCollection {
 	return &AdvancedRuleCollection{
 		style: "advanced",
+		mode:  modeFirst,
+		lists: listsReplace,
 		items: nil,
 	}
 }
@@ -44,6 +61,24 @@ func (ar *AdvancedRuleCollection) Unmarshal(reader *koanf.Koanf) (err error) {
 		}
 	}()
 	ar.style = reader.String("style")
+	if reader.Exists("mode") {
+		ar.mode = reader.String("mode")
+	}
+	if ar.mode != modeFirst && ar.mode != modeMerge {
+		return fmt.Errorf("unsupported mode %s", ar.mode)
+	}
+	if reader.Exists("merge_lists") {
+		ar.lists = reader.String("merge_lists")
+	}
+	if ar.lists != listsReplace && ar.lists != listsAppend {
+		return fmt.Errorf("unsupported merge_lists %s", ar.lists)
+	}
+	if reader.Exists("let") {
+		ar.bindings, err = unmarshalLet(reader)
+		if err != nil {
+			return err
+		}
+	}
 	slc := reader.Slices("rule")
 	for _, subReader := range slc {
 		var item AdvancedRuleItem
@@ -57,18 +92,14 @@ func (ar *AdvancedRuleCollection) Unmarshal(reader *koanf.Koanf) (err error) {
 }
 
 func (ar *AdvancedRuleCollection) Compile() error {
-	var err error
-	for i := range ar.items {
-		err = ar.items[i].Compile()
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return ar.CompileWithFunc(defaultCompileFunc)
 }
 
 func (ar *AdvancedRuleCollection) CompileWithFunc(compileFunc rule.CompileFunc) error {
-	var err error
+	err := compileLet(ar.bindings, compileFunc)
+	if err != nil {
+		return err
+	}
 	for i := range ar.items {
 		err = ar.items[i].CompileWithFunc(compileFunc)
 		if err != nil {
@@ -79,14 +110,67 @@ func (ar *AdvancedRuleCollection) CompileWithFunc(compileFunc rule.CompileFunc)
 }
 
 func (ar *AdvancedRuleCollection) Calculate(payload interface{}) (dto.Data, error) {
-	for _, item := range ar.items {
-		data, err := item.Calculate(payload)
+	return ar.Explain(payload, nil)
+}
+
+func (ar *AdvancedRuleCollection) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace != nil {
+		trace.Style = "advanced"
+	}
+	payload, err := bind(ar.bindings, payload, trace)
+	if err != nil {
+		return nil, err
+	}
+	if ar.mode == modeMerge {
+		return ar.merge(payload, trace)
+	}
+	for i := range ar.items {
+		data, err := ar.explainItem(i, payload, trace)
 		if err != nil {
 			return nil, err
 		}
 		if data != nil {
+			if trace != nil {
+				trace.Matched = true
+			}
 			return data, nil
 		}
 	}
+	if trace != nil {
+		trace.Fallback = true
+	}
 	return dto.Data{}, nil
 }
+
+func (ar *AdvancedRuleCollection) explainItem(i int, payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	sub := trace.Index("rule", i)
+	data, err := ar.items[i].Explain(payload, sub)
+	sub.Record(data, err)
+	return data, atPath(err, fmt.Sprintf("rule[%d]", i))
+}
+
+// merge evaluates every item and deep-merges the data of the matching ones in order.
+func (ar *AdvancedRuleCollection) merge(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	result := make(map[string]interface{})
+	for i := range ar.items {
+		data, err := ar.explainItem(i, payload, trace)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			if trace != nil {
+				trace.Matched = true
+			}
+			result = deepMerge(result, data, ar.lists == listsAppend)
+		}
+	}
+	return result, nil
+}
+
+func (ar *AdvancedRuleCollection) children() []rule.Ruler {
+	rulers := make([]rule.Ruler, 0, len(ar.items))
+	for _, item := range ar.items {
+		rulers = append(rulers, item)
+	}
+	return rulers
+}
@@ -0,0 +1,151 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/GGXXLL/rule/dto"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdvancedRuleCollection_Merge(t *testing.T) {
+	cases := []struct {
+		name    string
+		yaml    string
+		payload dto.Payload
+		expect  func(*testing.T, error, dto.Data)
+	}{
+		{
+			"merge",
+			`
+style: advanced
+mode: merge
+rule:
+  - if: true
+    then:
+      theme: light
+      limits:
+        daily: 10
+        monthly: 100
+      tags: [base]
+  - if: vip
+    then:
+      limits:
+        daily: 50
+      tags: [vip]
+  - if: platform == "ios"
+    child:
+      style: basic
+      rule:
+        theme: dark
+`,
+			dto.Payload{"vip": true, "platform": "ios"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{
+					"theme": "dark",
+					"limits": map[string]interface{}{
+						"daily":   50,
+						"monthly": 100,
+					},
+					"tags": []interface{}{"vip"},
+				}, data)
+			},
+		},
+		{
+			"append lists",
+			`
+style: advanced
+mode: merge
+merge_lists: append
+rule:
+  - if: true
+    then:
+      tags: [base]
+  - if: vip
+    then:
+      tags: [vip]
+  - if: platform == "ios"
+    then:
+      tags: [ios]
+`,
+			dto.Payload{"vip": true, "platform": "android"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{"tags": []interface{}{"base", "vip"}}, data)
+			},
+		},
+		{
+			"no match",
+			`
+style: advanced
+mode: merge
+rule:
+  - if: vip
+    then:
+      tags: [vip]
+`,
+			dto.Payload{"vip": false},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{}, data)
+			},
+		},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ar := NewAdvancedRule()
+			k := koanf.New(".")
+			err := k.Load(rawbytes.Provider([]byte(c.yaml)), yaml.Parser())
+			if !assert.NoError(t, err) {
+				return
+			}
+			err = ar.Unmarshal(k)
+			if !assert.NoError(t, err) {
+				return
+			}
+			err = ar.Compile()
+			if !assert.NoError(t, err) {
+				return
+			}
+			result, err := ar.Calculate(c.payload)
+			c.expect(t, err, result)
+
+			// the rule itself is not modified by merging
+			again, err := ar.Calculate(c.payload)
+			c.expect(t, err, again)
+		})
+	}
+}
+
+func TestAdvancedRuleCollection_UnmarshalMode(t *testing.T) {
+	for _, s := range []string{
+		`
+style: advanced
+mode: all
+rule:
+  - if: true
+    then:
+      i: 1
+`,
+		`
+style: advanced
+mode: merge
+merge_lists: prepend
+rule:
+  - if: true
+    then:
+      i: 1
+`,
+	} {
+		ar := NewAdvancedRule()
+		k := koanf.New(".")
+		err := k.Load(rawbytes.Provider([]byte(s)), yaml.Parser())
+		assert.NoError(t, err)
+		assert.Error(t, ar.Unmarshal(k))
+	}
+}
@@ -4,47 +4,22 @@ import (
 	"fmt"
 
 	"github.com/GGXXLL/rule"
-	"github.com/GGXXLL/rule/msg"
-	"github.com/antonmedv/expr/compiler"
-	"github.com/antonmedv/expr/parser"
 
 	"github.com/GGXXLL/rule/dto"
 	"github.com/antonmedv/expr/vm"
-	"github.com/hashicorp/go-multierror"
 	"github.com/knadh/koanf"
 	"github.com/pkg/errors"
 	"github.com/xeipuuv/gojsonschema"
 )
 
 type AdvancedRuleItem struct {
+	branch
 	cond    string
-	then    dto.Data
-	child   rule.Ruler
 	program *vm.Program
 }
 
 func (ar *AdvancedRuleItem) ValidateWithSchema(schema gojsonschema.JSONLoader) error {
-	if ar.then == nil && ar.child != nil {
-		return ar.child.ValidateWithSchema(schema)
-	}
-	document := gojsonschema.NewGoLoader(ar.then)
-	result, err := gojsonschema.Validate(schema, document)
-	if err != nil {
-		return errors.Wrap(err, "fails to validate with json schema")
-	}
-	if !result.Valid() {
-		var err multierror.Error
-		for i := range result.Errors() {
-			if result.Errors()[i] != nil {
-				err.Errors = append(err.Errors, fmt.Errorf(result.Errors()[i].String()))
-			}
-		}
-		if err.Len() > 0 {
-			return &err
-		}
-		return nil
-	}
-	return nil
+	return ar.validateWithSchema(schema)
 }
 
 func (ar *AdvancedRuleItem) Unmarshal(reader *koanf.Koanf) error {
@@ -52,60 +27,41 @@ func (ar *AdvancedRuleItem) Unmarshal(reader *koanf.Koanf) error {
 	if len(ar.cond) == 0 {
 		return errors.New("if condition not found in advanced rule")
 	}
-	err := reader.Unmarshal("then", &ar.then)
-	if err != nil {
-		return err
-	}
-	if ar.then == nil && reader.Exists("child") {
-		style := reader.MustString("child.style")
-		if style == "" {
-			return errors.New("missing child style")
-		}
-		item, err := NewRuler(style)
-		if err != nil {
-			return err
-		}
-		err = item.Unmarshal(reader.Cut("child"))
-		if err != nil {
-			return err
-		}
-		ar.child = item
-	}
-	return nil
+	return ar.unmarshal(reader)
 }
 
 func (ar *AdvancedRuleItem) Compile() error {
-	return ar.CompileWithFunc(func(s string) (*vm.Program, error) {
-		tree, err := parser.Parse(s)
-		if err != nil {
-			return nil, err
-		}
-		return compiler.Compile(tree, nil)
-	})
+	return ar.CompileWithFunc(defaultCompileFunc)
 }
 
 func (ar *AdvancedRuleItem) CompileWithFunc(compileFunc rule.CompileFunc) error {
-	var err error
-	ar.then = convert(ar.then)
-	ar.program, err = compileFunc(ar.cond)
+	program, err := compileFunc(ar.cond)
 	if err != nil {
 		return err
 	}
-	if ar.program == nil {
+	if program == nil {
 		return fmt.Errorf("invalid expression: %s", ar.cond)
 	}
-	if ar.child != nil {
-		if err = ar.child.Compile(); err != nil {
-			return err
-		}
-	}
-	return err
+	ar.program = program
+	return ar.compile(compileFunc)
 }
 
 func (ar *AdvancedRuleItem) Calculate(payload interface{}) (dto.Data, error) {
-	output, err := vm.Run(ar.program, payload)
+	return ar.Explain(payload, nil)
+}
+
+// Explain calculates the item and records the condition in the trace. The
+// result is nil if the condition is false.
+func (ar *AdvancedRuleItem) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace != nil {
+		trace.Condition = ar.cond
+	}
+	output, err := run(ar.program, payload, trace)
 	if err != nil {
-		return nil, errors.Wrap(err, msg.ErrorRules)
+		return nil, &rule.EvalError{Condition: ar.cond, Kind: rule.ErrExpression, Err: err}
+	}
+	if trace != nil {
+		trace.Value = output
 	}
 	if i, ok := output.(int); ok && i == 0 {
 		return nil, nil
@@ -113,11 +69,12 @@ func (ar *AdvancedRuleItem) Calculate(payload interface{}) (dto.Data, error) {
 	if b, ok := output.(bool); ok && !b {
 		return nil, nil
 	}
-	if ar.then != nil {
-		return ar.then, nil
-	}
-	if ar.child != nil {
-		return ar.child.Calculate(payload)
+	if trace != nil {
+		trace.Matched = true
 	}
-	return nil, nil
+	return ar.calculate(payload, trace)
+}
+
+func (ar *AdvancedRuleItem) children() []rule.Ruler {
+	return []rule.Ruler{ar.child}
 }
@@ -3,6 +3,7 @@ package entity
 import (
 	"fmt"
 
+	"github.com/GGXXLL/rule"
 	"github.com/GGXXLL/rule/dto"
 	"github.com/hashicorp/go-multierror"
 	"github.com/knadh/koanf"
@@ -13,10 +14,19 @@ import (
 type BasicRule struct {
 	style string
 	data  dto.Data `yaml:"then"`
+	// node is the compiled data, nil if data has no expression.
+	node valueNode
+	// template enables the templates in the strings of data.
+	template bool
 }
 
 func (br *BasicRule) ValidateWithSchema(schema gojsonschema.JSONLoader) error {
-	document := gojsonschema.NewGoLoader(br.data)
+	return validateData(schema, br.data)
+}
+
+// validateData validates the data against the json schema.
+func validateData(schema gojsonschema.JSONLoader, data dto.Data) error {
+	document := gojsonschema.NewGoLoader(data)
 	result, err := gojsonschema.Validate(schema, document)
 	if err != nil {
 		return errors.Wrap(err, "fails to validate with json schema")
@@ -45,10 +55,27 @@ func (br *BasicRule) Unmarshal(reader *koanf.Koanf) error {
 }
 
 func (br *BasicRule) Compile() error {
+	return br.CompileWithFunc(defaultCompileFunc)
+}
+
+func (br *BasicRule) CompileWithFunc(compileFunc rule.CompileFunc) (err error) {
 	br.data = convert(br.data)
-	return nil
+	br.node, err = compileData(br.data, compileFunc, br.template)
+	return err
 }
 
-func (br *BasicRule) Calculate(interface{}) (dto.Data, error) {
+func (br *BasicRule) Calculate(payload interface{}) (dto.Data, error) {
+	return br.Explain(payload, nil)
+}
+
+func (br *BasicRule) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace != nil {
+		trace.Style = "basic"
+		trace.Matched = true
+	}
+	if br.node != nil {
+		data, err := renderData(br.node, payload, trace)
+		return data, atPath(err, "rule")
+	}
 	return br.data, nil
 }
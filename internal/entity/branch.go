@@ -0,0 +1,65 @@
+package entity
+
+import (
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/knadh/koanf"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// branch is the outcome of a matched case, either the then data or a child rule.
+type branch struct {
+	then  dto.Data
+	child rule.Ruler
+	// thenNode is the compiled then, nil if then has no expression.
+	thenNode valueNode
+	// template enables the templates in the strings of then.
+	template bool
+}
+
+func (b *branch) unmarshal(reader *koanf.Koanf) (err error) {
+	err = reader.Unmarshal("then", &b.then)
+	if err != nil {
+		return err
+	}
+	if b.then == nil && reader.Exists("child") {
+		b.child, err = newChild(reader)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *branch) compile(compileFunc rule.CompileFunc) (err error) {
+	if b.child != nil {
+		if err = compileChild(b.child, compileFunc); err != nil {
+			return err
+		}
+	}
+	b.then = convert(b.then)
+	b.thenNode, err = compileData(b.then, compileFunc, b.template)
+	return err
+}
+
+func (b *branch) validateWithSchema(schema gojsonschema.JSONLoader) error {
+	if b.then == nil && b.child != nil {
+		return b.child.ValidateWithSchema(schema)
+	}
+	return validateData(schema, b.then)
+}
+
+func (b *branch) calculate(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if b.thenNode != nil {
+		data, err := renderData(b.thenNode, payload, trace)
+		return data, atPath(err, "then")
+	}
+	if b.then != nil {
+		return b.then, nil
+	}
+	if b.child != nil {
+		data, err := explain(b.child, payload, trace.Child("child"))
+		return data, atPath(err, "child")
+	}
+	return nil, nil
+}
@@ -0,0 +1,70 @@
+package entity
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalError(t *testing.T) {
+	ruler, err := NewRules(strings.NewReader(`
+style: advanced
+rule:
+  - if: name == "foo"
+    then:
+      i: 1
+  - if: true
+    child:
+      style: switch
+      by: platform
+      rule:
+        - case: ios
+          style: range
+          by: version
+          rule:
+            - then:
+                i: !expr 1 / zero
+      default:
+        style: advanced
+        rule:
+          - if: age > 10
+            then:
+              i: 2
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := []struct {
+		name      string
+		payload   dto.Payload
+		kind      error
+		path      string
+		condition string
+	}{
+		{"missing switch key", dto.Payload{"name": "bar"}, rule.ErrMissingKey, "rule[1].child", "platform"},
+		{"missing range key", dto.Payload{"name": "bar", "platform": "ios"}, rule.ErrMissingKey, "rule[1].child.rule[0]", "version"},
+		{"range key type", dto.Payload{"name": "bar", "platform": "ios", "version": "x"}, rule.ErrKeyType, "rule[1].child.rule[0]", "version"},
+		{"then expression", dto.Payload{"name": "bar", "platform": "ios", "version": 1}, rule.ErrExpression, "rule[1].child.rule[0].rule[0].then", "1 / zero"},
+		{"if expression", dto.Payload{"name": "bar", "platform": "android", "age": "x"}, rule.ErrExpression, "rule[1].child.default.rule[0]", "age > 10"},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ruler.Calculate(c.payload)
+			assert.True(t, errors.Is(err, c.kind), "%v", err)
+			var evalErr *rule.EvalError
+			if !assert.True(t, errors.As(err, &evalErr)) {
+				return
+			}
+			assert.Equal(t, c.path, evalErr.Path)
+			assert.Equal(t, c.condition, evalErr.Condition)
+			assert.NotNil(t, errors.Unwrap(err))
+		})
+	}
+}
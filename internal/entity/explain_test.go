@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	rules, err := NewRules(strings.NewReader(`
+style: switch
+by: platform
+rule:
+  - case: ios
+    style: advanced
+    rule:
+      - if: version < 2
+        then:
+          upgrade: true
+      - if: true
+        child:
+          style: range
+          by: version
+          rule:
+            - lt: 5
+              then:
+                tier: low
+            - gte: 5
+              then:
+                tier: high
+default:
+  style: basic
+  rule:
+    upgrade: false
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	data, trace, err := rule.Explain(rules, dto.Payload{"platform": "ios", "version": 6})
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"tier": "high"}, data)
+
+	assert.Equal(t, "switch", trace.Style)
+	assert.Equal(t, "platform", trace.By)
+	assert.Equal(t, "ios", trace.Case)
+	assert.True(t, trace.Matched)
+	if !assert.Len(t, trace.Children, 1) {
+		return
+	}
+	advanced := trace.Children[0]
+	assert.Equal(t, "rule[0]", advanced.Path)
+	assert.Equal(t, "advanced", advanced.Style)
+	if !assert.Len(t, advanced.Children, 2) {
+		return
+	}
+	assert.Equal(t, "version < 2", advanced.Children[0].Condition)
+	assert.Equal(t, false, advanced.Children[0].Value)
+	assert.False(t, advanced.Children[0].Matched)
+	assert.True(t, advanced.Children[1].Matched)
+	if !assert.Len(t, advanced.Children[1].Children, 1) {
+		return
+	}
+	ranged := advanced.Children[1].Children[0]
+	assert.Equal(t, "rule[0].rule[1].child", ranged.Path)
+	assert.Equal(t, "range", ranged.Style)
+	assert.Equal(t, "[5, +Inf)", ranged.Case)
+	assert.Equal(t, dto.Data{"tier": "high"}, ranged.Result)
+
+	_, err = json.Marshal(trace)
+	assert.NoError(t, err)
+
+	_, trace, err = rule.Explain(rules, dto.Payload{"platform": "android"})
+	assert.NoError(t, err)
+	assert.True(t, trace.Fallback)
+	assert.Equal(t, "default", trace.Children[0].Path)
+	assert.Equal(t, dto.Data{"upgrade": false}, trace.Result)
+
+	_, trace, err = rule.Explain(rules, dto.Payload{"version": 1})
+	assert.Error(t, err)
+	assert.NotEmpty(t, trace.Error)
+}
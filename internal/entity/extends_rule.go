@@ -0,0 +1,82 @@
+package entity
+
+import (
+	"fmt"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/knadh/koanf"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ExtendedRule is a rule document declaring extends, its result is the result
+// of the parent rule deep-merged with its own.
+type ExtendedRule struct {
+	rule.Ruler
+	parent   string
+	resolver rule.Resolver
+}
+
+// withExtends wraps the ruler if the document extends a parent rule.
+func withExtends(ruler rule.Ruler, reader *koanf.Koanf) rule.Ruler {
+	if parent := reader.String("extends"); parent != "" {
+		return &ExtendedRule{Ruler: ruler, parent: parent}
+	}
+	return ruler
+}
+
+// Extends returns the name of the parent rule, empty if the ruler extends none.
+func Extends(ruler rule.Ruler) string {
+	if e, ok := ruler.(*ExtendedRule); ok {
+		return e.parent
+	}
+	return ""
+}
+
+// ValidateWithSchema is a no-op, the own result is partial and is only
+// complete once merged with the parent.
+func (er *ExtendedRule) ValidateWithSchema(schema gojsonschema.JSONLoader) error {
+	return nil
+}
+
+func (er *ExtendedRule) CompileWithFunc(compileFunc rule.CompileFunc) error {
+	return compileChild(er.Ruler, compileFunc)
+}
+
+func (er *ExtendedRule) Calculate(payload interface{}) (dto.Data, error) {
+	return er.Explain(payload, nil)
+}
+
+// Explain records the parent in the extends child of the trace, and the own
+// rule in the trace itself.
+func (er *ExtendedRule) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	var parent rule.Ruler
+	if er.resolver != nil {
+		parent = er.resolver.GetRuler(er.parent)
+	}
+	if parent == nil {
+		return nil, &rule.EvalError{
+			Condition: er.parent,
+			Kind:      rule.ErrRuleNotFound,
+			Err:       fmt.Errorf("cannot resolve the parent %s", er.parent),
+		}
+	}
+	sub, err := trace.Refer("extends", er.parent)
+	if err != nil {
+		return nil, atPath(err, "extends")
+	}
+	sub.Ref = er.parent
+	base, err := explain(parent, payload, sub)
+	if err != nil {
+		return nil, atPath(err, "extends")
+	}
+	own, err := explain(er.Ruler, payload, trace)
+	if err != nil {
+		return nil, err
+	}
+	return deepMerge(deepMerge(nil, base, false), own, false), nil
+}
+
+func (er *ExtendedRule) children() []rule.Ruler {
+	return []rule.Ruler{er.Ruler}
+}
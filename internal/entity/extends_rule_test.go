@@ -0,0 +1,118 @@
+package entity
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+const baseRule = `
+style: basic
+rule:
+  theme: dark
+  limits:
+    daily: 10
+    monthly: 100
+`
+
+const regionRule = `
+extends: /base/app-config
+style: switch
+by: region
+rule:
+  - case: eu
+    style: basic
+    rule:
+      limits:
+        daily: 5
+default:
+  style: basic
+  rule:
+    currency: usd
+`
+
+func TestExtendedRule(t *testing.T) {
+	base, err := NewRules(strings.NewReader(baseRule))
+	if !assert.NoError(t, err) {
+		return
+	}
+	ruler, err := NewRules(strings.NewReader(regionRule))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "/base/app-config", Extends(ruler))
+	assert.Equal(t, []string{"/base/app-config"}, References(ruler))
+
+	_, err = ruler.Calculate(dto.Payload{"region": "eu"})
+	assert.True(t, errors.Is(err, rule.ErrRuleNotFound))
+
+	Resolve(ruler, mapResolver{"/base/app-config": base})
+	data, err := ruler.Calculate(dto.Payload{"region": "eu"})
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"theme": "dark", "limits": map[string]interface{}{"daily": 5, "monthly": 100}}, data)
+
+	// the result of the parent is not modified
+	data, err = ruler.Calculate(dto.Payload{"region": "us"})
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"theme": "dark", "currency": "usd", "limits": map[string]interface{}{"daily": 10, "monthly": 100}}, data)
+
+	_, trace, err := rule.Explain(ruler, dto.Payload{"region": "eu"})
+	assert.NoError(t, err)
+	assert.Equal(t, "switch", trace.Style)
+	assert.Equal(t, "extends", trace.Children[0].Path)
+	assert.Equal(t, "/base/app-config", trace.Children[0].Ref)
+	assert.Equal(t, "basic", trace.Children[0].Style)
+	assert.Equal(t, "eu", trace.Case)
+}
+
+func TestValidateRules_Extends(t *testing.T) {
+	base, _ := NewRules(strings.NewReader(baseRule))
+	loop, _ := NewRules(strings.NewReader(`
+extends: /region/eu
+style: basic`))
+	resolver := mapResolver{"/base/app-config": base, "/base/loop": loop}
+
+	cases := []struct {
+		name string
+		rule string
+		opts []Option
+		err  string
+	}{
+		{"valid", regionRule + `
+tests:
+  - given:
+      url: http://example.com?region=eu
+    expect: theme == "dark" && limits.daily == 5
+`, []Option{WithResolver(resolver)}, ""},
+		{"without resolver", regionRule, nil, "missing parent /base/app-config"},
+		{"missing parent", `
+extends: /base/missing
+style: basic`, []Option{WithResolver(resolver)}, "missing parent /base/missing"},
+		{"cycle", `
+extends: /base/loop
+style: basic`, []Option{WithResolver(resolver), WithName("/region/eu")}, "extends cycle /region/eu -> /base/loop -> /region/eu"},
+		{"self", `
+extends: /region/eu
+style: basic`, []Option{WithResolver(resolver), WithName("/region/eu")}, "extends cycle /region/eu -> /region/eu"},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateRules(strings.NewReader(c.rule), c.opts...)
+			if c.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var invalid *ErrInvalidRules
+			if assert.ErrorAs(t, err, &invalid) {
+				assert.Equal(t, "extends", invalid.Key)
+				assert.Equal(t, c.err, invalid.Error())
+			}
+		})
+	}
+}
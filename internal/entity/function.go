@@ -0,0 +1,246 @@
+package entity
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/internal/function"
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/compiler"
+	"github.com/antonmedv/expr/parser"
+	"github.com/antonmedv/expr/vm"
+)
+
+// contextKey is the name of the context of the evaluation in the environment
+// of the expressions, it is not a valid identifier so that it cannot be
+// shadowed by the payload.
+const contextKey = "$context"
+
+var timeType = reflect.TypeOf(&function.Time{})
+
+// clock builds the time functions of each call, see functionPatch.
+type clock struct {
+	now      rule.Clock
+	location *time.Location
+}
+
+// At returns the time functions at the time of the clock of ctx, or else
+// that of the options, in the location named by tz, or else the location of
+// ctx, or else that of the options. It fails if tz is not a known location.
+func (c *clock) At(tz, ctx interface{}) (*function.Time, error) {
+	now, loc := c.now, c.location
+	if ctx, ok := ctx.(context.Context); ok {
+		clock, location := rule.TimeOf(ctx)
+		if clock != nil {
+			now = clock
+		}
+		if location != nil {
+			loc = location
+		}
+	}
+	if now == nil {
+		now = time.Now
+	}
+	if name, ok := tz.(string); ok && name != "" {
+		var err error
+		if loc, err = function.LoadLocation(name); err != nil {
+			return nil, err
+		}
+	}
+	return function.NewTime(now(), loc), nil
+}
+
+// functionPatch makes the builtin and the registered functions available to
+// the expressions, whatever the type of the payload is. It rewrites the calls
+// to the functions so that they are not looked up in the payload, which is
+// left untouched: foo(x) is called on the functions as functions.foo(x), and
+// a time function such as IsToday(x) as clock.At(tz, $context).IsToday(x).
+type functionPatch struct {
+	functions map[string]interface{}
+	clock     *clock
+}
+
+func newFunctionPatch(o options) *functionPatch {
+	functions := make(map[string]interface{}, len(function.Conversions)+len(o.functions))
+	for name, fn := range function.Conversions {
+		functions[name] = fn
+	}
+	for name, fn := range o.functions {
+		functions[name] = fn
+	}
+	return &functionPatch{functions: functions, clock: &clock{now: o.clock, location: o.location}}
+}
+
+func (p *functionPatch) Enter(node *ast.Node) {}
+
+func (p *functionPatch) Exit(node *ast.Node) {
+	call, ok := (*node).(*ast.FunctionNode)
+	if !ok {
+		return
+	}
+	var receiver ast.Node
+	if _, ok := p.functions[call.Name]; ok {
+		receiver = &ast.ConstantNode{Value: p.functions}
+	} else if _, ok := timeType.MethodByName(call.Name); ok {
+		receiver = &ast.MethodNode{
+			Node:   &ast.ConstantNode{Value: p.clock},
+			Method: "At",
+			Arguments: []ast.Node{
+				&ast.IdentifierNode{Value: "tz", NilSafe: true},
+				&ast.IdentifierNode{Value: contextKey, NilSafe: true},
+			},
+		}
+	} else {
+		return
+	}
+	ast.Patch(node, &ast.MethodNode{Node: receiver, Method: call.Name, Arguments: call.Arguments})
+}
+
+// ErrShadowed is returned when an expression calls a function which has the
+// same name as a method or a field of the env, the function would be called
+// instead of the latter.
+type ErrShadowed struct {
+	Name string
+}
+
+func (e *ErrShadowed) Error() string {
+	return fmt.Sprintf("function %s shadows the %s of the payload, rename one of them", e.Name, e.Name)
+}
+
+// shadowVisitor finds the first call to the names.
+type shadowVisitor struct {
+	names map[string]bool
+	found string
+}
+
+func (v *shadowVisitor) Enter(node *ast.Node) {}
+
+func (v *shadowVisitor) Exit(node *ast.Node) {
+	if call, ok := (*node).(*ast.FunctionNode); ok && v.found == "" && v.names[call.Name] {
+		v.found = call.Name
+	}
+}
+
+// checkShadowed fails with an ErrShadowed if the expression calls any of the
+// shadowed names.
+func checkShadowed(tree *parser.Tree, shadowed map[string]bool) error {
+	if len(shadowed) == 0 {
+		return nil
+	}
+	v := &shadowVisitor{names: shadowed}
+	ast.Walk(&tree.Node, v)
+	if v.found != "" {
+		return &ErrShadowed{Name: v.found}
+	}
+	return nil
+}
+
+// compileFunc returns the CompileFunc compiling the expressions without type
+// checking, with the segments of lookup and the functions of the patch.
+func (p *functionPatch) compileFunc(lookup SegmentFunc) rule.CompileFunc {
+	return func(s string) (*vm.Program, error) {
+		tree, err := parse(s, lookup)
+		if err != nil {
+			return nil, err
+		}
+		ast.Walk(&tree.Node, p)
+		return compiler.Compile(tree, nil)
+	}
+}
+
+// run runs the program against the payload. If the program uses the time
+// functions and the context of the trace sets the clock or the location, it
+// runs against a copy of the payload along with the context.
+func run(program *vm.Program, payload interface{}, trace *rule.Trace) (interface{}, error) {
+	ctx := trace.Context()
+	if clock, loc := rule.TimeOf(ctx); clock == nil && loc == nil || !usesClock(program) {
+		return vm.Run(program, payload)
+	}
+	env := scope(payload)
+	env[contextKey] = ctx
+	return vm.Run(program, env)
+}
+
+func usesClock(program *vm.Program) bool {
+	for _, c := range program.Constants {
+		if _, ok := c.(*clock); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scope copies the payload into a map: the methods, then the fields, or the
+// entries of a map, the latter taking precedence. The fields are also copied
+// under the names of their structs or json tags, unless taken, so that the
+// keys of the switch, rollout and range rules are found as well. The methods
+// of a dto.Payload are the builtin functions, which are skipped.
+func scope(payload interface{}) map[string]interface{} {
+	env := make(map[string]interface{})
+	v := reflect.ValueOf(payload)
+	if _, ok := payload.(dto.Payload); !ok && v.IsValid() {
+		for i := 0; i < v.NumMethod(); i++ {
+			env[v.Type().Method(i).Name] = v.Method(i).Interface()
+		}
+	}
+	d := reflect.Indirect(v)
+	switch d.Kind() {
+	case reflect.Map:
+		if d.Type().Key().Kind() == reflect.String {
+			for _, k := range d.MapKeys() {
+				env[k.String()] = d.MapIndex(k).Interface()
+			}
+		}
+	case reflect.Struct:
+		for name, value := range fields(d) {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// fields returns the exported fields of the struct by name and by tag name,
+// including those promoted from the embedded structs, the outer fields and
+// the names taking precedence.
+func fields(d reflect.Value) map[string]interface{} {
+	var (
+		values   = make(map[string]interface{})
+		tagged   = make(map[string]interface{})
+		embedded []reflect.Value
+	)
+	for i := 0; i < d.NumField(); i++ {
+		f := d.Type().Field(i)
+		if f.Anonymous {
+			e := reflect.Indirect(d.Field(i))
+			if e.Kind() == reflect.Struct {
+				embedded = append(embedded, e)
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		values[f.Name] = d.Field(i).Interface()
+		for _, tag := range []string{"structs", "json"} {
+			if name := tagName(f, tag); name != "" {
+				tagged[name] = d.Field(i).Interface()
+			}
+		}
+	}
+	for name, value := range tagged {
+		if _, ok := values[name]; !ok {
+			values[name] = value
+		}
+	}
+	for _, e := range embedded {
+		for name, value := range fields(e) {
+			if _, ok := values[name]; !ok {
+				values[name] = value
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,368 @@
+package entity
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+type embeddedPayload struct {
+	letPayload
+	Name string
+}
+
+func TestWithFunction(t *testing.T) {
+	upper := WithFunction("Upper", strings.ToUpper)
+	cases := []struct {
+		name    string
+		rule    string
+		opts    []Option
+		payload interface{}
+		asserts func(t *testing.T, data dto.Data, err error)
+	}{
+		{
+			"custom",
+			`
+style: advanced
+rule:
+  - if: Upper(name) == "FOO"
+    then:
+      name: !expr Upper(name)`,
+			[]Option{upper},
+			dto.Payload{"name": "foo"},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "FOO", data["name"])
+			},
+		},
+		{
+			"builtin with struct payload",
+			`
+style: advanced
+rule:
+  - if: DaysAgo("2020-01-01 00:00:00") > 1 && Level == 1
+    then:
+      old: true`,
+			nil,
+			letPayload{Level: 1},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, true, data["old"])
+			},
+		},
+		{
+			"promoted fields",
+			`
+style: advanced
+rule:
+  - if: Upper(Name) == "FOO" && Double(Level) == 4
+    then:
+      sms: 1`,
+			[]Option{upper},
+			&embeddedPayload{letPayload: letPayload{Level: 2}, Name: "foo"},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"replaces builtin",
+			`
+style: advanced
+rule:
+  - if: DaysAgo(register_at) == 42
+    then:
+      sms: 1`,
+			[]Option{WithFunction("DaysAgo", func(s string) int { return 42 })},
+			dto.Payload{"register_at": "2020-01-01 00:00:00"},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"payload field takes precedence",
+			`
+style: advanced
+rule:
+  - if: Upper == "foo"
+    then:
+      sms: 1`,
+			[]Option{upper},
+			dto.Payload{"Upper": "foo"},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+	}
+
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ruler, err := NewRules(strings.NewReader(c.rule), c.opts...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := ruler.Calculate(c.payload)
+			c.asserts(t, data, err)
+		})
+	}
+}
+
+func TestWithFunction_TaggedPayload(t *testing.T) {
+	type payload struct {
+		UserID   int    `structs:"user_id"`
+		Platform string `json:"platform"`
+	}
+	upper := WithFunction("Upper", strings.ToUpper)
+	cases := []struct {
+		name    string
+		rule    string
+		payload interface{}
+	}{
+		{
+			"switch",
+			`
+style: switch
+by: platform
+rule:
+  - case: ios
+    style: basic
+    rule:
+      x: 1`,
+			payload{Platform: "ios"},
+		},
+		{
+			"rollout",
+			`
+style: rollout
+by: user_id
+salt: a
+rule:
+  - weight: 100
+    then:
+      x: 1`,
+			payload{UserID: 1},
+		},
+		{
+			"nested in advanced",
+			`
+style: advanced
+rule:
+  - if: Upper(Platform) == "IOS" && IsAfter("2020-01-01")
+    child:
+      style: switch
+      by: platform
+      rule:
+        - case: ios
+          style: basic
+          rule:
+            x: 1`,
+			&payload{Platform: "ios"},
+		},
+		{
+			"nested in let",
+			`
+style: advanced
+let:
+  platform: Upper(Platform)
+rule:
+  - if: platform == "IOS"
+    child:
+      style: rollout
+      by: user_id
+      salt: a
+      rule:
+        - weight: 100
+          then:
+            x: 1`,
+			payload{UserID: 1, Platform: "ios"},
+		},
+	}
+
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ruler, err := NewRules(strings.NewReader(c.rule), upper)
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := ruler.Calculate(c.payload)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, data["x"])
+		})
+	}
+}
+
+func TestWithFunction_Validate(t *testing.T) {
+	r := `
+style: advanced
+tests:
+  - given:
+      url: http://monetization.tagtic.cn?name=foo
+    expect: sms == 1
+rule:
+  - if: Upper(name) == "FOO"
+    then:
+      sms: 1`
+	assert.Error(t, ValidateRules(strings.NewReader(r)))
+	assert.NoError(t, ValidateRules(strings.NewReader(r), WithFunction("Upper", strings.ToUpper)))
+
+	// the functions are type checked against the env
+	err := ValidateRules(strings.NewReader(`
+style: advanced
+rule:
+  - if: Upper(Level) == "FOO"
+    then:
+      sms: 1`), WithEnv(letPayload{}), WithFunction("Upper", strings.ToUpper))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot use int as argument")
+	}
+}
+
+type todayPayload struct {
+	Date string
+}
+
+func (todayPayload) IsToday() bool {
+	return true
+}
+
+func TestWithFunction_Shadowed(t *testing.T) {
+	cases := []struct {
+		name   string
+		cond   string
+		opts   []Option
+		shadow string
+	}{
+		{"registered", "Double(Level) > 2", []Option{WithEnv(letPayload{}), WithFunction("Double", func(i int) int { return i })}, "Double"},
+		{"builtin", `IsToday("2022-01-01")`, []Option{WithEnv(todayPayload{})}, "IsToday"},
+		{"not called", "Level > 2", []Option{WithEnv(letPayload{}), WithFunction("Double", func(i int) int { return i })}, ""},
+		{"dto.Payload", `IsToday("2022-01-01")`, []Option{WithEnv(dto.Payload{})}, ""},
+		{"without env", "Double(Level) > 2", []Option{WithFunction("Double", func(i int) int { return i })}, ""},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := NewRules(strings.NewReader(`
+style: advanced
+rule:
+  - if: `+c.cond+`
+    then:
+      sms: 1`), c.opts...)
+			if c.shadow == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var shadowed *ErrShadowed
+			if assert.ErrorAs(t, err, &shadowed) {
+				assert.Equal(t, c.shadow, shadowed.Name)
+			}
+		})
+	}
+}
+
+func TestWithFunction_Extends(t *testing.T) {
+	ruler, err := NewRules(strings.NewReader(`
+extends: base
+style: basic
+rule:
+  b: 1`))
+	assert.NoError(t, err)
+	assert.Equal(t, "base", Extends(ruler))
+	assert.Equal(t, []string{"base"}, References(ruler))
+	_, err = ruler.(rule.Explainer).Explain(nil, &rule.Trace{})
+	var evalErr *rule.EvalError
+	assert.ErrorAs(t, err, &evalErr)
+}
+
+func TestWithClock(t *testing.T) {
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	// 2022-01-01 20:00 in UTC is 2022-01-02 04:00 in Shanghai
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+	r := `
+style: advanced
+rule:
+  - if: IsToday("2022-01-01")
+    then:
+      day: 1
+  - if: IsToday("2022-01-02")
+    then:
+      day: 2`
+
+	background := context.Background()
+	timed := rule.WithLocation(rule.WithClock(background, rule.FixedClock(now)), time.UTC)
+
+	cases := []struct {
+		name    string
+		opts    []Option
+		ctx     context.Context
+		payload interface{}
+		day     interface{}
+	}{
+		{"clock", []Option{WithClock(rule.FixedClock(now)), WithLocation(time.UTC)}, background, dto.Payload{}, 1},
+		{"location", []Option{WithClock(rule.FixedClock(now)), WithLocation(shanghai)}, background, dto.Payload{}, 2},
+		{"payload tz", []Option{WithClock(rule.FixedClock(now)), WithLocation(time.UTC)}, background, dto.Payload{"tz": "Asia/Shanghai"}, 2},
+		{"context", nil, rule.WithLocation(rule.WithClock(background, rule.FixedClock(now)), shanghai), dto.Payload{}, 2},
+		{
+			"context overrides options",
+			[]Option{WithClock(rule.FixedClock(now.AddDate(0, 0, 5))), WithLocation(shanghai)},
+			timed,
+			dto.Payload{},
+			1,
+		},
+		{"payload tz overrides context", nil, timed, map[string]interface{}{"tz": "Asia/Shanghai"}, 2},
+		{"struct payload", nil, timed, letPayload{}, 1},
+	}
+
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ruler, err := NewRules(strings.NewReader(r), c.opts...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := rule.CalculateContext(c.ctx, ruler, c.payload)
+			assert.NoError(t, err)
+			assert.Equal(t, c.day, data["day"])
+		})
+	}
+
+	ruler, _ := NewRules(strings.NewReader(r))
+	_, err := ruler.Calculate(dto.Payload{"tz": "Mars/Olympus"})
+	if assert.ErrorIs(t, err, rule.ErrExpression) {
+		assert.Contains(t, err.Error(), "unknown time zone Mars/Olympus")
+	}
+}
+
+func TestTestCase_Now(t *testing.T) {
+	r := `
+style: advanced
+tests:
+  - given:
+      url: http://monetization.tagtic.cn?tz=Asia/Shanghai
+    now: 2022-01-01T20:00:00Z
+    expect: promotion == true
+  - given:
+      url: http://monetization.tagtic.cn
+    now: "2022-01-03 10:00:00"
+    expect: promotion == false
+rule:
+  - if: IsBetween("2022-01-02", "2022-01-03")
+    then:
+      promotion: true
+  - if: true
+    then:
+      promotion: false`
+	assert.NoError(t, ValidateRules(strings.NewReader(r)))
+}
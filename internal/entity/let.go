@@ -0,0 +1,236 @@
+package entity
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/internal/function"
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/checker"
+	"github.com/antonmedv/expr/compiler"
+	"github.com/antonmedv/expr/conf"
+	"github.com/antonmedv/expr/parser"
+	"github.com/antonmedv/expr/vm"
+	"github.com/knadh/koanf"
+	"github.com/spf13/cast"
+)
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// binding is a local variable of an advanced rule, computed once per evaluation.
+type binding struct {
+	name    string
+	expr    string
+	program *vm.Program
+}
+
+// unmarshalLet reads the let block. The bindings are sorted by name, except
+// that each one comes after the bindings it uses.
+func unmarshalLet(reader *koanf.Koanf) ([]*binding, error) {
+	raw, ok := reader.Get("let").(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("let must be a map of expressions")
+	}
+	names := make([]string, 0, len(raw))
+	exprs := make(map[string]string, len(raw))
+	for name, value := range raw {
+		s, err := cast.ToStringE(value)
+		if err != nil {
+			return nil, fmt.Errorf("let %s: %w", name, err)
+		}
+		names = append(names, name)
+		exprs[name] = s
+	}
+	sort.Strings(names)
+
+	var (
+		bindings []*binding
+		state    = make(map[string]int)
+	)
+	var visit func(path []string, name string) error
+	visit = func(path []string, name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("let cycle %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range identifiers(exprs[name]) {
+			if _, ok := exprs[dep]; ok && dep != name {
+				if err := visit(append(path, name), dep); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = 2
+		bindings = append(bindings, &binding{name: name, expr: exprs[name]})
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(nil, name); err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+type identifierVisitor struct {
+	names []string
+}
+
+func (v *identifierVisitor) Enter(node *ast.Node) {}
+
+func (v *identifierVisitor) Exit(node *ast.Node) {
+	if n, ok := (*node).(*ast.IdentifierNode); ok {
+		v.names = append(v.names, n.Value)
+	}
+}
+
+// identifiers returns the variables used by the expression, nil if it is
+// invalid, the error is reported when it is compiled.
+func identifiers(s string) []string {
+	tree, err := parser.Parse(s)
+	if err != nil {
+		return nil
+	}
+	var v identifierVisitor
+	ast.Walk(&tree.Node, &v)
+	return v.names
+}
+
+func compileLet(bindings []*binding, compileFunc rule.CompileFunc) error {
+	for _, b := range bindings {
+		program, err := compileFunc(b.expr)
+		if err != nil {
+			return fmt.Errorf("let %s: %w", b.name, err)
+		}
+		if program == nil {
+			return fmt.Errorf("invalid expression: %s", b.expr)
+		}
+		b.program = program
+	}
+	return nil
+}
+
+// bind returns the payload with the values of the bindings, which are
+// evaluated in order so that each one can use the previous ones. The payload
+// itself is left untouched.
+func bind(bindings []*binding, payload interface{}, trace *rule.Trace) (interface{}, error) {
+	if len(bindings) == 0 {
+		return payload, nil
+	}
+	env, vars := envOf(payload)
+	if trace != nil {
+		trace.Let = make(map[string]interface{}, len(bindings))
+	}
+	for _, b := range bindings {
+		value, err := run(b.program, env, trace)
+		if err != nil {
+			return nil, atPath(&rule.EvalError{Condition: b.expr, Kind: rule.ErrExpression, Err: err}, "let."+b.name)
+		}
+		vars[b.name] = value
+		if trace != nil {
+			trace.Let[b.name] = value
+		}
+	}
+	return env, nil
+}
+
+// envOf copies the payload into a map that the bindings can be added to. A
+// dto.Payload stays a dto.Payload to keep its methods, the fields and methods
+// of other payloads are copied as values.
+func envOf(payload interface{}) (interface{}, map[string]interface{}) {
+	switch p := payload.(type) {
+	case dto.Payload:
+		env := make(dto.Payload, len(p))
+		for k, v := range p {
+			env[k] = v
+		}
+		return env, env
+	case map[string]interface{}:
+		env := make(map[string]interface{}, len(p))
+		for k, v := range p {
+			env[k] = v
+		}
+		return env, env
+	}
+
+	env := scope(payload)
+	return env, env
+}
+
+// typedCompileFunc returns the CompileFunc type checking the expressions
+// against env, the functions and the let bindings of the ruler, then applying
+// the patch, the segments of lookup are expanded first. The bindings of all the
+// advanced rules are checked in turn, so a binding may use those of the rules
+// above it.
+func typedCompileFunc(ruler rule.Ruler, env interface{}, patch *functionPatch, lookup SegmentFunc) (rule.CompileFunc, error) {
+	types := conf.CreateTypesTable(env)
+	if types == nil {
+		types = make(conf.TypesTable)
+	}
+	// the methods of a dto.Payload are the builtin functions themselves
+	_, builtin := env.(dto.Payload)
+	shadowed := make(map[string]bool)
+	for _, m := range []map[string]interface{}{function.Builtins(new(function.Time)), patch.functions} {
+		for name := range m {
+			if _, ok := types[name]; ok && !builtin {
+				shadowed[name] = true
+			}
+		}
+	}
+	for _, m := range []map[string]interface{}{function.Builtins(new(function.Time)), patch.functions} {
+		for name, fn := range m {
+			types[name] = conf.Tag{Type: reflect.TypeOf(fn)}
+		}
+	}
+	check := func(s string) (*parser.Tree, *conf.Config, reflect.Type, error) {
+		tree, err := parse(s, lookup)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := checkShadowed(tree, shadowed); err != nil {
+			return nil, nil, nil, err
+		}
+		config := conf.New(env)
+		config.Types = types
+		t, err := checker.Check(tree, config)
+		return tree, config, t, err
+	}
+
+	var err error
+	walk(ruler, func(r rule.Ruler) {
+		ar, ok := r.(*AdvancedRuleCollection)
+		if !ok || err != nil {
+			return
+		}
+		for _, b := range ar.bindings {
+			_, _, t, checkErr := check(b.expr)
+			if checkErr != nil {
+				err = fmt.Errorf("let %s: %w", b.name, checkErr)
+				return
+			}
+			if t == nil {
+				t = interfaceType
+			}
+			types[b.name] = conf.Tag{Type: t}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(s string) (*vm.Program, error) {
+		tree, config, _, err := check(s)
+		if err != nil {
+			return nil, err
+		}
+		ast.Walk(&tree.Node, patch)
+		return compiler.Compile(tree, config)
+	}, nil
+}
@@ -0,0 +1,225 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+type letPayload struct {
+	Level int
+	Paid  bool
+}
+
+func (p letPayload) Double(i int) int {
+	return i * 2
+}
+
+func TestLet(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    string
+		payload interface{}
+		asserts func(t *testing.T, data dto.Data, err error)
+	}{
+		{
+			"shared by items",
+			`
+style: advanced
+let:
+  score: level * 10
+  vip: score >= 50
+rule:
+  - if: vip
+    then:
+      sms: !expr score
+  - if: true
+    then:
+      sms: 0`,
+			dto.Payload{"level": 6},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 60, data["sms"])
+			},
+		},
+		{
+			"payload methods",
+			`
+style: advanced
+let:
+  days: DaysAgo(register_at)
+rule:
+  - if: days > 1
+    then:
+      old: true`,
+			dto.Payload{"register_at": "2020-01-01 00:00:00"},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, true, data["old"])
+			},
+		},
+		{
+			"struct payload",
+			`
+style: advanced
+let:
+  score: Double(Level)
+rule:
+  - if: Paid && score == 10
+    then:
+      sms: 1`,
+			letPayload{Level: 5, Paid: true},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"child",
+			`
+style: advanced
+let:
+  score: level * 10
+rule:
+  - if: true
+    child:
+      style: advanced
+      let:
+        bonus: score + 1
+      rule:
+        - if: bonus > score
+          then:
+            sms: !expr bonus`,
+			dto.Payload{"level": 1},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 11, data["sms"])
+			},
+		},
+		{
+			"error",
+			`
+style: advanced
+let:
+  score: level.foo()
+rule:
+  - if: true
+    then:
+      sms: 1`,
+			dto.Payload{"level": 1},
+			func(t *testing.T, data dto.Data, err error) {
+				var evalErr *rule.EvalError
+				if assert.ErrorAs(t, err, &evalErr) {
+					assert.Equal(t, "let.score", evalErr.Path)
+				}
+			},
+		},
+	}
+
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ruler, err := NewRules(strings.NewReader(c.rule))
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := ruler.Calculate(c.payload)
+			c.asserts(t, data, err)
+		})
+	}
+}
+
+func TestLet_Trace(t *testing.T) {
+	ruler, err := NewRules(strings.NewReader(`
+style: advanced
+let:
+  score: level * 10
+rule:
+  - if: score > 0
+    then:
+      sms: 1`))
+	assert.NoError(t, err)
+	trace := &rule.Trace{}
+	payload := dto.Payload{"level": 2}
+	_, err = ruler.(rule.Explainer).Explain(payload, trace)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"score": 20}, trace.Let)
+	assert.NotContains(t, payload, "score")
+}
+
+func TestLet_Validate(t *testing.T) {
+	cases := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{
+			"typed",
+			`
+style: advanced
+let:
+  score: Double(Level)
+rule:
+  - if: Paid && score > 1
+    then:
+      sms: 1`,
+			"",
+		},
+		{
+			"binding type",
+			`
+style: advanced
+let:
+  name: "'foo'"
+rule:
+  - if: name > 1
+    then:
+      sms: 1`,
+			"invalid operation",
+		},
+		{
+			"unknown variable",
+			`
+style: advanced
+let:
+  score: Levels * 2
+rule:
+  - if: score > 1
+    then:
+      sms: 1`,
+			"let score: unknown name Levels",
+		},
+		{
+			"cycle",
+			`
+style: advanced
+let:
+  a: b + 1
+  b: a + 1
+rule:
+  - if: a > 1
+    then:
+      sms: 1`,
+			"let cycle a -> b -> a",
+		},
+	}
+
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateRules(strings.NewReader(c.rule), WithEnv(letPayload{}))
+			if c.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), c.err)
+			}
+		})
+	}
+}
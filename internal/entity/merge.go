@@ -0,0 +1,54 @@
+package entity
+
+import "github.com/GGXXLL/rule/dto"
+
+// deepMerge merges src into dst recursively and returns dst. Nested maps are
+// merged key by key, other values in src replace the ones in dst. Lists are
+// concatenated if appendLists is true. Maps and lists taken from src are
+// copied, so that src is never modified by a later merge.
+func deepMerge(dst, src map[string]interface{}, appendLists bool) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{}, len(src))
+	}
+	for k, v := range src {
+		if sm, ok := asMap(v); ok {
+			if dm, ok := asMap(dst[k]); ok {
+				dst[k] = deepMerge(dm, sm, appendLists)
+				continue
+			}
+		}
+		if sl, ok := v.([]interface{}); ok && appendLists {
+			if dl, ok := dst[k].([]interface{}); ok {
+				dst[k] = append(dl, deepCopy(sl).([]interface{})...)
+				continue
+			}
+		}
+		dst[k] = deepCopy(v)
+	}
+	return dst
+}
+
+// deepCopy copies the maps and lists in v.
+func deepCopy(v interface{}) interface{} {
+	if m, ok := asMap(v); ok {
+		return deepMerge(nil, m, false)
+	}
+	if l, ok := v.([]interface{}); ok {
+		c := make([]interface{}, len(l))
+		for i := range l {
+			c[i] = deepCopy(l[i])
+		}
+		return c
+	}
+	return v
+}
+
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case dto.Data:
+		return m, true
+	}
+	return nil, false
+}
@@ -0,0 +1,138 @@
+package entity
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exprTag marks a scalar as an expression, eg. `discount: !expr price * 0.1`.
+const exprTag = "!expr"
+
+// expression is the value of a scalar tagged with !expr, it is compiled as an
+// expression wherever it is in the then blocks, whether the rule enables the
+// templates or not.
+type expression string
+
+// yamlParser is a koanf.Parser like the koanf yaml parser, in addition it
+// decodes the scalars tagged with !expr as expressions, which would otherwise
+// be decoded as plain strings.
+type yamlParser struct{}
+
+func (p yamlParser) Unmarshal(b []byte) (map[string]interface{}, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	exprs := make(map[*yaml.Node]bool)
+	if err := rewriteExprTags(&node, "", exprs); err != nil {
+		return nil, err
+	}
+	rewriteTestTimes(&node)
+	var out map[string]interface{}
+	if err := node.Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(exprs) > 0 {
+		markExpressions(&node, out, exprs)
+	}
+	return out, nil
+}
+
+func (p yamlParser) Marshal(o map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(o)
+}
+
+// rewriteExprTags collects the scalars tagged with !expr into exprs and
+// retags them as strings so that they decode. key is the mapping key of node.
+func rewriteExprTags(node *yaml.Node, key string, exprs map[*yaml.Node]bool) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == exprTag {
+		if key == "if" {
+			return fmt.Errorf("line %d: if can't be tagged with %s, it is an expression already", node.Line, exprTag)
+		}
+		node.Tag = "!!str"
+		exprs[node] = true
+		return nil
+	}
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := rewriteExprTags(node.Content[i+1], node.Content[i].Value, exprs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, n := range node.Content {
+		if err := rewriteExprTags(n, "", exprs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markExpressions replaces the values decoded from the scalars of exprs with
+// expressions, v is the value decoded from node.
+func markExpressions(node *yaml.Node, v interface{}, exprs map[*yaml.Node]bool) interface{} {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			return markExpressions(node.Content[0], v, exprs)
+		}
+	case yaml.AliasNode:
+		return markExpressions(node.Alias, v, exprs)
+	case yaml.ScalarNode:
+		if s, ok := v.(string); ok && exprs[node] {
+			return expression(s)
+		}
+	case yaml.MappingNode:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i].Value
+			if sub, ok := m[k]; ok {
+				m[k] = markExpressions(node.Content[i+1], sub, exprs)
+			}
+		}
+	case yaml.SequenceNode:
+		l, ok := v.([]interface{})
+		if !ok || len(l) != len(node.Content) {
+			break
+		}
+		for i := range l {
+			l[i] = markExpressions(node.Content[i], l[i], exprs)
+		}
+	}
+	return v
+}
+
+// rewriteTestTimes keeps the now of the tests as written, instead of a UTC
+// timestamp, so that a date time without offset is in the location of the
+// payload.
+func rewriteTestTimes(node *yaml.Node) {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	tests := mappingValue(node, "tests")
+	if tests == nil || tests.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, test := range tests.Content {
+		if now := mappingValue(test, "now"); now != nil && now.Kind == yaml.ScalarNode && now.Tag == "!!timestamp" {
+			now.Tag = "!!str"
+		}
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package entity
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/GGXXLL/rule/dto"
+)
+
+// valueOf returns the value at the dotted path in the payload, eg.
+// "device.platform". Each segment is looked up in maps by key, and in
+// structs by the structs or json tag, or the field name.
+func valueOf(payload interface{}, path string) (interface{}, bool) {
+	cur := payload
+	for _, key := range strings.Split(path, ".") {
+		v, ok := fieldOf(cur, key)
+		if !ok {
+			return nil, false
+		}
+		cur = v
+	}
+	return cur, true
+}
+
+func fieldOf(v interface{}, key string) (interface{}, bool) {
+	switch m := v.(type) {
+	case dto.Payload:
+		f, ok := m[key]
+		return f, ok
+	case dto.Data:
+		f, ok := m[key]
+		return f, ok
+	case map[string]interface{}:
+		f, ok := m[key]
+		return f, ok
+	case map[interface{}]interface{}:
+		f, ok := m[key]
+		return f, ok
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		f := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+		if !f.IsValid() {
+			return nil, false
+		}
+		return f.Interface(), true
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			if tagName(sf, "structs") == key || tagName(sf, "json") == key {
+				return rv.Field(i).Interface(), true
+			}
+		}
+		if sf, ok := t.FieldByName(key); ok && sf.PkgPath == "" {
+			return rv.FieldByIndex(sf.Index).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+func tagName(sf reflect.StructField, tag string) string {
+	name := strings.Split(sf.Tag.Get(tag), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,195 @@
+package entity
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/hashicorp/go-multierror"
+	"github.com/knadh/koanf"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// RangeRule selects a branch by comparing a numeric payload field against
+// ordered, half-open ranges [gte, lt).
+type RangeRule struct {
+	style    string
+	by       string
+	ranges   []*rangeBranch
+	fallback rule.Ruler
+}
+
+type rangeBranch struct {
+	branch
+	gte float64
+	lt  float64
+	// index is the position in the rule document, before sorting.
+	index int
+}
+
+func (rb *rangeBranch) String() string {
+	return fmt.Sprintf("[%v, %v)", rb.gte, rb.lt)
+}
+
+func NewRangeRule() *RangeRule {
+	return &RangeRule{style: "range"}
+}
+
+func (rr *RangeRule) ValidateWithSchema(schema gojsonschema.JSONLoader) error {
+	var err multierror.Error
+	for _, b := range rr.ranges {
+		if e := b.validateWithSchema(schema); e != nil {
+			err.Errors = append(err.Errors, e)
+		}
+	}
+	if rr.fallback != nil {
+		if e := rr.fallback.ValidateWithSchema(schema); e != nil {
+			err.Errors = append(err.Errors, e)
+		}
+	}
+	if err.Len() > 0 {
+		return &err
+	}
+	return nil
+}
+
+func (rr *RangeRule) Unmarshal(reader *koanf.Koanf) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %s", r)
+		}
+	}()
+	rr.style = reader.String("style")
+	rr.by = reader.MustString("by")
+	for i, subReader := range reader.Slices("rule") {
+		b := rangeBranch{gte: math.Inf(-1), lt: math.Inf(1), index: i}
+		if subReader.Exists("gte") {
+			b.gte, err = cast.ToFloat64E(subReader.Get("gte"))
+			if err != nil {
+				return errors.Wrap(err, "invalid gte")
+			}
+		}
+		if subReader.Exists("lt") {
+			b.lt, err = cast.ToFloat64E(subReader.Get("lt"))
+			if err != nil {
+				return errors.Wrap(err, "invalid lt")
+			}
+		}
+		if err = b.unmarshal(subReader); err != nil {
+			return err
+		}
+		rr.ranges = append(rr.ranges, &b)
+	}
+	if reader.Exists("default") {
+		rr.fallback, err = NewRuler(reader.String("default.style"))
+		if err != nil {
+			return err
+		}
+		err = rr.fallback.Unmarshal(reader.Cut("default"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rr *RangeRule) Compile() error {
+	return rr.CompileWithFunc(nil)
+}
+
+// CompileWithFunc sorts the ranges for binary search. Overlapping ranges are
+// rejected, so are gaps unless a default is provided.
+func (rr *RangeRule) CompileWithFunc(compileFunc rule.CompileFunc) error {
+	if len(rr.ranges) == 0 {
+		return errors.New("range rule has no range")
+	}
+	sort.SliceStable(rr.ranges, func(i, j int) bool {
+		return rr.ranges[i].gte < rr.ranges[j].gte
+	})
+	for i, b := range rr.ranges {
+		if b.gte >= b.lt {
+			return fmt.Errorf("range %s is empty", b)
+		}
+		if b.then == nil && b.child == nil {
+			return fmt.Errorf("range %s has neither then nor child", b)
+		}
+		if i > 0 {
+			prev := rr.ranges[i-1]
+			if prev.lt > b.gte {
+				return fmt.Errorf("range %s overlaps %s", prev, b)
+			}
+			if prev.lt < b.gte && rr.fallback == nil {
+				return fmt.Errorf("gap between range %s and %s, provide a default", prev, b)
+			}
+		}
+		if err := b.compile(compileFunc); err != nil {
+			return err
+		}
+	}
+	if rr.fallback == nil {
+		if first := rr.ranges[0]; !math.IsInf(first.gte, -1) {
+			return fmt.Errorf("values below %v are not covered, provide a default", first.gte)
+		}
+		if last := rr.ranges[len(rr.ranges)-1]; !math.IsInf(last.lt, 1) {
+			return fmt.Errorf("values from %v are not covered, provide a default", last.lt)
+		}
+		return nil
+	}
+	return compileChild(rr.fallback, compileFunc)
+}
+
+func (rr *RangeRule) Calculate(payload interface{}) (dto.Data, error) {
+	return rr.Explain(payload, nil)
+}
+
+func (rr *RangeRule) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace != nil {
+		trace.Style = "range"
+		trace.By = rr.by
+	}
+	by, ok := valueOf(payload, rr.by)
+	if !ok {
+		return nil, &rule.EvalError{Condition: rr.by, Kind: rule.ErrMissingKey, Err: fmt.Errorf("range by non-exist key %s", rr.by)}
+	}
+	v, err := cast.ToFloat64E(by)
+	if err != nil {
+		return nil, &rule.EvalError{Condition: rr.by, Kind: rule.ErrKeyType, Err: errors.Wrap(err, "can only range by numeric type")}
+	}
+	if trace != nil {
+		trace.Value = by
+	}
+	i := sort.Search(len(rr.ranges), func(i int) bool {
+		return rr.ranges[i].lt > v
+	})
+	if i < len(rr.ranges) && rr.ranges[i].gte <= v {
+		b := rr.ranges[i]
+		if trace != nil {
+			trace.Matched = true
+			trace.Case = b.String()
+		}
+		sub := trace.Index("rule", b.index)
+		data, err := b.calculate(payload, sub)
+		sub.Record(data, err)
+		return data, atPath(err, fmt.Sprintf("rule[%d]", b.index))
+	}
+	if trace != nil {
+		trace.Fallback = true
+	}
+	if rr.fallback == nil {
+		return dto.Data{}, nil
+	}
+	data, err := explain(rr.fallback, payload, trace.Child("default"))
+	return data, atPath(err, "default")
+}
+
+func (rr *RangeRule) children() []rule.Ruler {
+	rulers := make([]rule.Ruler, 0, len(rr.ranges)+1)
+	for _, b := range rr.ranges {
+		rulers = append(rulers, b.child)
+	}
+	return append(rulers, rr.fallback)
+}
@@ -0,0 +1,233 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/GGXXLL/rule/dto"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeRule_Calculate(t *testing.T) {
+	rule := `
+style: range
+by: age
+rule:
+  - gte: 60
+    then:
+      i: 3
+  - lt: 18
+    then:
+      i: 1
+  - gte: 18
+    lt: 60
+    child:
+      style: advanced
+      rule:
+        - if: vip
+          then:
+            i: 4
+        - if: true
+          then:
+            i: 2
+`
+	cases := []struct {
+		name    string
+		payload dto.Payload
+		expect  func(*testing.T, error, dto.Data)
+	}{
+		{
+			"lower bound",
+			dto.Payload{"age": 0, "vip": false},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["i"])
+			},
+		},
+		{
+			"inclusive gte",
+			dto.Payload{"age": 18, "vip": false},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 2, data["i"])
+			},
+		},
+		{
+			"child",
+			dto.Payload{"age": 59.9, "vip": true},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 4, data["i"])
+			},
+		},
+		{
+			"exclusive lt",
+			dto.Payload{"age": "60", "vip": false},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 3, data["i"])
+			},
+		},
+		{
+			"not numeric",
+			dto.Payload{"age": "foo"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			"missing key",
+			dto.Payload{"name": "foo"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			rr := NewRangeRule()
+			k := koanf.New(".")
+			err := k.Load(rawbytes.Provider([]byte(rule)), yaml.Parser())
+			assert.NoError(t, err)
+			err = rr.Unmarshal(k)
+			assert.NoError(t, err)
+			err = rr.Compile()
+			assert.NoError(t, err)
+			result, err := rr.Calculate(c.payload)
+			c.expect(t, err, result)
+		})
+	}
+}
+
+func TestRangeRule_Compile(t *testing.T) {
+	cases := []struct {
+		name    string
+		yaml    string
+		payload dto.Payload
+		expect  func(*testing.T, error, dto.Data)
+	}{
+		{
+			"overlap",
+			`
+style: range
+by: age
+rule:
+  - lt: 20
+    then:
+      i: 1
+  - gte: 18
+    then:
+      i: 2
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			nil,
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			"gap",
+			`
+style: range
+by: age
+rule:
+  - lt: 18
+    then:
+      i: 1
+  - gte: 20
+    then:
+      i: 2
+`,
+			nil,
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			"not covered",
+			`
+style: range
+by: age
+rule:
+  - gte: 18
+    lt: 60
+    then:
+      i: 1
+`,
+			nil,
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			"empty range",
+			`
+style: range
+by: age
+rule:
+  - gte: 18
+    lt: 18
+    then:
+      i: 1
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			nil,
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			"gap with default",
+			`
+style: range
+by: age
+rule:
+  - lt: 18
+    then:
+      i: 1
+  - gte: 20
+    lt: 30
+    then:
+      i: 2
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			dto.Payload{"age": 19},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 3, data["i"])
+			},
+		},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			rr := NewRangeRule()
+			k := koanf.New(".")
+			err := k.Load(rawbytes.Provider([]byte(c.yaml)), yaml.Parser())
+			assert.NoError(t, err)
+			err = rr.Unmarshal(k)
+			assert.NoError(t, err)
+			err = rr.Compile()
+			if err != nil {
+				c.expect(t, err, nil)
+				return
+			}
+			result, err := rr.Calculate(c.payload)
+			c.expect(t, err, result)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package entity
+
+import (
+	"fmt"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/knadh/koanf"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// RefRule delegates to another rule by name, which is looked up through the
+// resolver at evaluation time, so that a common block is written once.
+type RefRule struct {
+	style    string
+	name     string
+	resolver rule.Resolver
+}
+
+func NewRefRule() *RefRule {
+	return &RefRule{style: "ref"}
+}
+
+// ValidateWithSchema is a no-op, the referenced rule is validated on its own.
+func (ref *RefRule) ValidateWithSchema(schema gojsonschema.JSONLoader) error {
+	return nil
+}
+
+func (ref *RefRule) Unmarshal(reader *koanf.Koanf) error {
+	ref.style = reader.String("style")
+	ref.name = reader.String("name")
+	if ref.name == "" {
+		return errors.New("missing name in ref rule")
+	}
+	return nil
+}
+
+func (ref *RefRule) Compile() error {
+	return nil
+}
+
+func (ref *RefRule) CompileWithFunc(compileFunc rule.CompileFunc) error {
+	return nil
+}
+
+func (ref *RefRule) Calculate(payload interface{}) (dto.Data, error) {
+	return ref.Explain(payload, nil)
+}
+
+func (ref *RefRule) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace != nil {
+		trace.Style = "ref"
+		trace.Ref = ref.name
+	}
+	var target rule.Ruler
+	if ref.resolver != nil {
+		target = ref.resolver.GetRuler(ref.name)
+	}
+	if target == nil {
+		return nil, &rule.EvalError{
+			Condition: ref.name,
+			Kind:      rule.ErrRuleNotFound,
+			Err:       fmt.Errorf("cannot resolve the reference to %s", ref.name),
+		}
+	}
+	sub, err := trace.Refer("ref", ref.name)
+	if err != nil {
+		return nil, atPath(err, "ref")
+	}
+	data, err := explain(target, payload, sub)
+	if trace != nil {
+		trace.Matched = sub.Matched
+		trace.Fallback = sub.Fallback
+	}
+	return data, atPath(err, "ref")
+}
+
+// parent is a ruler containing other rulers.
+type parent interface {
+	children() []rule.Ruler
+}
+
+// walk calls fn with the ruler and its descendants, the referenced rules are
+// not visited.
+func walk(ruler rule.Ruler, fn func(rule.Ruler)) {
+	if ruler == nil {
+		return
+	}
+	fn(ruler)
+	if p, ok := ruler.(parent); ok {
+		for _, c := range p.children() {
+			walk(c, fn)
+		}
+	}
+}
+
+// References returns the names of the rules referenced or extended by the ruler.
+func References(ruler rule.Ruler) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	walk(ruler, func(r rule.Ruler) {
+		switch x := r.(type) {
+		case *ExtendedRule:
+			add(x.parent)
+		case *RefRule:
+			add(x.name)
+		}
+	})
+	return names
+}
+
+// Resolve sets the resolver looking up the rules referenced or extended by the ruler.
+func Resolve(ruler rule.Ruler, resolver rule.Resolver) {
+	walk(ruler, func(r rule.Ruler) {
+		switch x := r.(type) {
+		case *ExtendedRule:
+			x.resolver = resolver
+		case *RefRule:
+			x.resolver = resolver
+		}
+	})
+}
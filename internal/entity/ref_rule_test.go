@@ -0,0 +1,193 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/knadh/koanf"
+	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+type mapResolver map[string]rule.Ruler
+
+func (m mapResolver) GetRuler(ruleName string) rule.Ruler {
+	return m[ruleName]
+}
+
+func TestRefRule(t *testing.T) {
+	vip, err := NewRules(strings.NewReader(`
+style: switch
+by: level
+rule:
+  - case: 1
+    style: basic
+    rule:
+      discount: 0.9
+default:
+  style: basic
+  rule:
+    discount: 1`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	ruler, err := NewRules(strings.NewReader(`
+style: advanced
+rule:
+  - if: vip
+    child:
+      ref: /common/vip
+  - if: true
+    child:
+      style: switch
+      by: platform
+      rule:
+        - case: ios
+          style: ref
+          name: /common/vip
+      default:
+        style: ref
+        name: /common/missing`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"/common/vip", "/common/missing"}, References(ruler))
+
+	// unresolved
+	_, err = ruler.Calculate(dto.Payload{"vip": true, "level": 1})
+	assert.True(t, errors.Is(err, rule.ErrRuleNotFound))
+
+	Resolve(ruler, mapResolver{"/common/vip": vip})
+	cases := []struct {
+		name    string
+		payload dto.Payload
+		expect  dto.Data
+		err     error
+	}{
+		{"child ref", dto.Payload{"vip": true, "level": 1}, dto.Data{"discount": 0.9}, nil},
+		{"ref style", dto.Payload{"vip": false, "platform": "ios", "level": 2}, dto.Data{"discount": 1}, nil},
+		{"missing", dto.Payload{"vip": false, "platform": "android"}, nil, rule.ErrRuleNotFound},
+		{"error in the referenced rule", dto.Payload{"vip": true}, nil, rule.ErrMissingKey},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := ruler.Calculate(c.payload)
+			if c.err != nil {
+				assert.True(t, errors.Is(err, c.err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expect, data)
+		})
+	}
+
+	_, trace, err := rule.Explain(ruler, dto.Payload{"vip": true, "level": 1})
+	assert.NoError(t, err)
+	ref := trace.Children[0].Children[0]
+	assert.Equal(t, "rule[0].child", ref.Path)
+	assert.Equal(t, "ref", ref.Style)
+	assert.Equal(t, "/common/vip", ref.Ref)
+	assert.True(t, ref.Matched)
+	assert.Equal(t, "rule[0].child.ref", ref.Children[0].Path)
+	assert.Equal(t, "1", ref.Children[0].Case)
+
+	_, err = ruler.Calculate(dto.Payload{"vip": true})
+	var evalErr *rule.EvalError
+	if assert.True(t, errors.As(err, &evalErr)) {
+		assert.Equal(t, "rule[0].child.ref", evalErr.Path)
+	}
+
+	_, err = NewRules(strings.NewReader(`style: ref`))
+	assert.Error(t, err)
+}
+
+func TestRefRule_Cycle(t *testing.T) {
+	a, err := NewRules(strings.NewReader(`
+style: advanced
+rule:
+  - if: level > 1
+    child:
+      ref: b
+  - if: true
+    then:
+      discount: 1`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	b, err := NewRules(strings.NewReader(`
+extends: a
+style: basic
+rule:
+  vip: true`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resolver := mapResolver{"a": a, "b": b}
+	Resolve(a, resolver)
+	Resolve(b, resolver)
+
+	data, err := a.Calculate(dto.Payload{"level": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"discount": 1}, data)
+
+	_, err = a.Calculate(dto.Payload{"level": 2})
+	assert.True(t, errors.Is(err, rule.ErrReferenceCycle))
+	var evalErr *rule.EvalError
+	if assert.True(t, errors.As(err, &evalErr)) {
+		assert.Equal(t, "rule[0].child.ref.extends.rule[0].child.ref", evalErr.Path)
+		assert.Equal(t, "b -> a -> b", evalErr.Err.Error())
+	}
+
+	// the cycle is found when tracing as well, even below a shallow trace
+	_, _, err = rule.Explain(a, dto.Payload{"level": 2})
+	assert.True(t, errors.Is(err, rule.ErrReferenceCycle))
+	_, err = a.(rule.Explainer).Explain(dto.Payload{"level": 2}, rule.ShallowTrace())
+	assert.True(t, errors.Is(err, rule.ErrReferenceCycle))
+}
+
+// payloadRuler is a custom ruler expecting a dto.Payload.
+type payloadRuler struct{}
+
+func (payloadRuler) Unmarshal(reader *koanf.Koanf) error                     { return nil }
+func (payloadRuler) Compile() error                                          { return nil }
+func (payloadRuler) ValidateWithSchema(schema gojsonschema.JSONLoader) error { return nil }
+
+func (payloadRuler) Calculate(payload interface{}) (dto.Data, error) {
+	p, ok := payload.(dto.Payload)
+	if !ok {
+		return nil, fmt.Errorf("custom got %T", payload)
+	}
+	return dto.Data{"name": p["name"]}, nil
+}
+
+func TestRefRule_Custom(t *testing.T) {
+	ruler, err := NewRules(strings.NewReader(`
+style: ref
+name: custom`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	Resolve(ruler, mapResolver{"custom": payloadRuler{}})
+
+	data, err := ruler.Calculate(dto.Payload{"name": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"name": "x"}, data)
+
+	data, _, err = rule.Explain(ruler, dto.Payload{"name": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"name": "x"}, data)
+
+	// the clock of the evaluation is not passed through the payload
+	ctx := rule.WithClock(context.Background(), rule.FixedClock(time.Now()))
+	data, err = rule.CalculateContext(ctx, ruler, dto.Payload{"name": "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"name": "x"}, data)
+}
@@ -0,0 +1,149 @@
+package entity
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/hashicorp/go-multierror"
+	"github.com/knadh/koanf"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// buckets is the number of buckets the payload is hashed into.
+const buckets = 100
+
+// RolloutRule picks a variant by hashing a payload field into a bucket, so
+// that the same value always gets the same variant.
+type RolloutRule struct {
+	style    string
+	by       string
+	salt     string
+	variants []*rolloutVariant
+}
+
+type rolloutVariant struct {
+	branch
+	weight int
+}
+
+func NewRolloutRule() *RolloutRule {
+	return &RolloutRule{style: "rollout"}
+}
+
+func (ro *RolloutRule) ValidateWithSchema(schema gojsonschema.JSONLoader) error {
+	var err multierror.Error
+	for _, v := range ro.variants {
+		if e := v.validateWithSchema(schema); e != nil {
+			err.Errors = append(err.Errors, e)
+		}
+	}
+	if err.Len() > 0 {
+		return &err
+	}
+	return nil
+}
+
+func (ro *RolloutRule) Unmarshal(reader *koanf.Koanf) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %s", r)
+		}
+	}()
+	ro.style = reader.String("style")
+	ro.by = reader.MustString("by")
+	ro.salt = reader.String("salt")
+	for _, subReader := range reader.Slices("rule") {
+		v := rolloutVariant{weight: subReader.Int("weight")}
+		if err = v.unmarshal(subReader); err != nil {
+			return err
+		}
+		ro.variants = append(ro.variants, &v)
+	}
+	return nil
+}
+
+func (ro *RolloutRule) Compile() error {
+	return ro.CompileWithFunc(nil)
+}
+
+func (ro *RolloutRule) CompileWithFunc(compileFunc rule.CompileFunc) error {
+	total := 0
+	for i, v := range ro.variants {
+		if v.weight < 0 {
+			return fmt.Errorf("rollout variant %d has negative weight %d", i, v.weight)
+		}
+		total += v.weight
+		if v.then == nil && v.child == nil {
+			return fmt.Errorf("rollout variant %d has neither then nor child", i)
+		}
+		if err := v.compile(compileFunc); err != nil {
+			return err
+		}
+	}
+	if total != buckets {
+		return fmt.Errorf("rollout weights should sum to %d, got %d", buckets, total)
+	}
+	return nil
+}
+
+func (ro *RolloutRule) Calculate(payload interface{}) (dto.Data, error) {
+	return ro.Explain(payload, nil)
+}
+
+func (ro *RolloutRule) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace != nil {
+		trace.Style = "rollout"
+		trace.By = ro.by
+	}
+	by, ok := valueOf(payload, ro.by)
+	if !ok {
+		return nil, &rule.EvalError{Condition: ro.by, Kind: rule.ErrMissingKey, Err: fmt.Errorf("rollout by non-exist key %s", ro.by)}
+	}
+	b, err := ro.bucket(by)
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil {
+		trace.Value = by
+		trace.Matched = true
+	}
+	for i, v := range ro.variants {
+		if b < v.weight {
+			if trace != nil {
+				trace.Case = fmt.Sprintf("rule[%d]", i)
+			}
+			sub := trace.Index("rule", i)
+			data, err := v.calculate(payload, sub)
+			sub.Record(data, err)
+			return data, atPath(err, fmt.Sprintf("rule[%d]", i))
+		}
+		b -= v.weight
+	}
+	return dto.Data{}, nil
+}
+
+// bucket hashes the salt and the canonical string form of the value into
+// [0, buckets), so that 42 and "42" fall into the same bucket.
+func (ro *RolloutRule) bucket(value interface{}) (int, error) {
+	s, err := cast.ToStringE(value)
+	if err != nil {
+		return 0, &rule.EvalError{Condition: ro.by, Kind: rule.ErrKeyType, Err: errors.Wrap(err, "can only rollout by scalar type")}
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(ro.salt))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(s))
+	return int(h.Sum32() % buckets), nil
+}
+
+func (ro *RolloutRule) children() []rule.Ruler {
+	rulers := make([]rule.Ruler, 0, len(ro.variants))
+	for _, v := range ro.variants {
+		rulers = append(rulers, v.child)
+	}
+	return rulers
+}
@@ -0,0 +1,188 @@
+package entity
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/GGXXLL/rule/dto"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadRolloutRule(t *testing.T, s string) (*RolloutRule, error) {
+	t.Helper()
+	ro := NewRolloutRule()
+	k := koanf.New(".")
+	err := k.Load(rawbytes.Provider([]byte(s)), yaml.Parser())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if err := ro.Unmarshal(k); err != nil {
+		return nil, err
+	}
+	return ro, ro.Compile()
+}
+
+func TestRolloutRule_Calculate(t *testing.T) {
+	ro, err := loadRolloutRule(t, `
+style: rollout
+by: user_id
+salt: exp-1
+rule:
+  - weight: 10
+    then:
+      i: 1
+  - weight: 0
+    then:
+      i: 2
+  - weight: 90
+    child:
+      style: advanced
+      rule:
+        - if: true
+          then:
+            i: 3
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	count := make(map[int]int)
+	for i := 0; i < 10000; i++ {
+		data, err := ro.Calculate(dto.Payload{"user_id": i})
+		if !assert.NoError(t, err) {
+			return
+		}
+		count[data["i"].(int)]++
+
+		// sticky across calls and key types
+		again, err := ro.Calculate(dto.Payload{"user_id": strconv.Itoa(i)})
+		assert.NoError(t, err)
+		assert.Equal(t, data, again)
+	}
+	assert.InDelta(t, 1000, count[1], 150)
+	assert.Equal(t, 0, count[2])
+	assert.InDelta(t, 9000, count[3], 150)
+
+	// struct payload
+	type payload struct {
+		UserID int `structs:"user_id"`
+	}
+	data, err := ro.Calculate(payload{UserID: 1})
+	assert.NoError(t, err)
+	expected, _ := ro.Calculate(dto.Payload{"user_id": 1})
+	assert.Equal(t, expected, data)
+
+	_, err = ro.Calculate(dto.Payload{"name": "foo"})
+	assert.Error(t, err)
+}
+
+func TestRolloutRule_Salt(t *testing.T) {
+	a, err := loadRolloutRule(t, `
+style: rollout
+by: user_id
+salt: a
+rule:
+  - weight: 50
+    then:
+      i: 1
+  - weight: 50
+    then:
+      i: 2
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	b, err := loadRolloutRule(t, `
+style: rollout
+by: user_id
+salt: b
+rule:
+  - weight: 50
+    then:
+      i: 1
+  - weight: 50
+    then:
+      i: 2
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	diff := 0
+	for i := 0; i < 1000; i++ {
+		x, _ := a.Calculate(dto.Payload{"user_id": i})
+		y, _ := b.Calculate(dto.Payload{"user_id": i})
+		if x["i"] != y["i"] {
+			diff++
+		}
+	}
+	assert.InDelta(t, 500, diff, 100)
+}
+
+func TestRolloutRule_Compile(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+	}{
+		{
+			"less than 100",
+			`
+style: rollout
+by: user_id
+rule:
+  - weight: 10
+    then:
+      i: 1
+  - weight: 80
+    then:
+      i: 2
+`,
+		},
+		{
+			"more than 100",
+			`
+style: rollout
+by: user_id
+rule:
+  - weight: 20
+    then:
+      i: 1
+  - weight: 90
+    then:
+      i: 2
+`,
+		},
+		{
+			"negative",
+			`
+style: rollout
+by: user_id
+rule:
+  - weight: -10
+    then:
+      i: 1
+  - weight: 110
+    then:
+      i: 2
+`,
+		},
+		{
+			"missing then",
+			`
+style: rollout
+by: user_id
+rule:
+  - weight: 100
+`,
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadRolloutRule(t, c.yaml)
+			assert.Error(t, err)
+		})
+	}
+}
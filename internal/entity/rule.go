@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/GGXXLL/rule"
+	"github.com/antonmedv/expr/compiler"
+	"github.com/antonmedv/expr/parser"
 	"github.com/antonmedv/expr/vm"
 
 	"github.com/GGXXLL/rule/dto"
 	"github.com/knadh/koanf"
-	kyaml "github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/pkg/errors"
 	"github.com/xeipuuv/gojsonschema"
@@ -24,6 +27,12 @@ func NewRuler(style string) (rule.Ruler, error) {
 		return NewBasicRule(), nil
 	case "switch":
 		return NewSwitchRule(), nil
+	case "rollout":
+		return NewRolloutRule(), nil
+	case "range":
+		return NewRangeRule(), nil
+	case "ref":
+		return NewRefRule(), nil
 	case "":
 		return NewBasicRule(), nil
 	default:
@@ -31,6 +40,77 @@ func NewRuler(style string) (rule.Ruler, error) {
 	}
 }
 
+// defaultCompileFunc compiles the expression without type checking, so that
+// any payload can be used.
+func defaultCompileFunc(s string) (*vm.Program, error) {
+	tree, err := parser.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return compiler.Compile(tree, nil)
+}
+
+// newChild unmarshals the rule under the child key, child.ref is short for
+// a ref rule.
+func newChild(reader *koanf.Koanf) (rule.Ruler, error) {
+	if name := reader.String("child.ref"); name != "" {
+		return &RefRule{style: "ref", name: name}, nil
+	}
+	style := reader.String("child.style")
+	if style == "" {
+		return nil, errors.New("missing child style")
+	}
+	child, err := NewRuler(style)
+	if err != nil {
+		return nil, err
+	}
+	err = child.Unmarshal(reader.Cut("child"))
+	if err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// compileChild compiles the child rule with the compileFunc if the child supports it.
+func compileChild(child rule.Ruler, compileFunc rule.CompileFunc) error {
+	if customRuler, ok := child.(rule.CustomRuler); ok && compileFunc != nil {
+		return customRuler.CompileWithFunc(compileFunc)
+	}
+	return child.Compile()
+}
+
+// explain calculates the ruler and records the trace if the ruler supports it.
+func explain(ruler rule.Ruler, payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace == nil {
+		return ruler.Calculate(payload)
+	}
+	var (
+		data dto.Data
+		err  error
+	)
+	if e, ok := ruler.(rule.Explainer); ok {
+		data, err = e.Explain(payload, trace)
+	} else {
+		data, err = ruler.Calculate(payload)
+	}
+	trace.Record(data, err)
+	return data, err
+}
+
+// atPath prefixes the path of the EvalError with key, so that the error
+// locates the failing node once it reaches the root.
+func atPath(err error, key string) error {
+	var evalErr *rule.EvalError
+	if errors.As(err, &evalErr) {
+		if evalErr.Path == "" {
+			evalErr.Path = key
+		} else {
+			evalErr.Path = key + "." + evalErr.Path
+		}
+	}
+	return err
+}
+
 type Config struct {
 	Style string       `yaml:"style"`
 	Rules []rule.Ruler `yaml:"rule"`
@@ -59,6 +139,11 @@ type CentralRules struct {
 
 type ErrInvalidRules struct {
 	detail string
+	// Key is the top level key that fails validation, "tests" or "def". It is
+	// empty if the rule itself cannot be loaded or compiled.
+	Key string
+	// Index is the index of the failing test case if Key is "tests".
+	Index int
 }
 
 func (e *ErrInvalidRules) Error() string {
@@ -88,7 +173,7 @@ func convert(i interface{}) dto.Data {
 	return i.(dto.Data)
 }
 
-func newRules(reader io.Reader) (rule.Ruler, error) {
+func newRules(reader io.Reader) (rule.Ruler, *koanf.Koanf, error) {
 	var (
 		b   []byte
 		err error
@@ -96,81 +181,273 @@ func newRules(reader io.Reader) (rule.Ruler, error) {
 	c := koanf.New(".")
 	b, err = io.ReadAll(reader)
 	if err != nil {
-		return nil, errors.Wrap(err, "reader is not valid")
+		return nil, nil, errors.Wrap(err, "reader is not valid")
 	}
 
-	err = c.Load(rawbytes.Provider(b), kyaml.Parser())
+	err = c.Load(rawbytes.Provider(b), yamlParser{})
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot load yaml")
+		return nil, nil, errors.Wrap(err, "cannot load yaml")
 	}
 
 	ruler, err := NewRuler(c.String("style"))
 	if err != nil {
-		return nil, errors.Wrap(err, "invalid rules")
+		return nil, nil, errors.Wrap(err, "invalid rules")
 	}
 	err = ruler.Unmarshal(c)
 	if err != nil {
-		return nil, errors.Wrap(err, "invalid rules")
+		return nil, nil, errors.Wrap(err, "invalid rules")
 	}
-	return ruler, nil
+	if c.Bool("template") {
+		enableTemplates(ruler)
+	}
+	return withExtends(ruler, c), c, nil
 }
 
-func NewRules(reader io.Reader) (rule.Ruler, error) {
-	ruler, err := newRules(reader)
+// compile compiles the ruler with the compileFunc, or if it is nil, with the
+// segments and the functions of the options and type checking against the env
+// of the options if any.
+func compile(ruler rule.Ruler, c *koanf.Koanf, compileFunc rule.CompileFunc, o options) error {
+	if compileFunc == nil {
+		lookup := segmentFunc(c, o)
+		patch := newFunctionPatch(o)
+		compileFunc = patch.compileFunc(lookup)
+		if o.env != nil {
+			var err error
+			compileFunc, err = typedCompileFunc(ruler, o.env, patch, lookup)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return compileChild(ruler, compileFunc)
+}
+
+func NewRules(reader io.Reader, opts ...Option) (rule.Ruler, error) {
+	o := newOptions(opts)
+	ruler, c, err := newRules(reader)
 	if err != nil {
 		return nil, err
 	}
-	err = ruler.Compile()
+	err = compile(ruler, c, nil, o)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't compile")
 	}
+	if o.resolver != nil {
+		Resolve(ruler, o.resolver)
+	}
 	return ruler, nil
 }
 
-func NewCustomRules(reader io.Reader, compileFunc func(string) (*vm.Program, error)) (rule.Ruler, error) {
-	ruler, err := newRules(reader)
+func NewCustomRules(reader io.Reader, compileFunc func(string) (*vm.Program, error), opts ...Option) (rule.Ruler, error) {
+	o := newOptions(opts)
+	ruler, c, err := newRules(reader)
 	if err != nil {
 		return nil, err
 	}
-	customRuler, ok := ruler.(rule.CustomRuler)
-	if !ok {
+	if _, ok := ruler.(rule.CustomRuler); !ok {
 		return nil, errors.New("this rule do not support custom")
 	}
-	err = customRuler.CompileWithFunc(compileFunc)
+	err = compile(ruler, c, compileFunc, o)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't compile")
 	}
+	if o.resolver != nil {
+		Resolve(ruler, o.resolver)
+	}
 	return ruler, nil
 }
 
-func ValidateRules(reader io.Reader) error {
+// Option configures NewRules, NewCustomRules and ValidateRules.
+type Option func(o *options)
+
+type options struct {
+	name      string
+	resolver  rule.Resolver
+	segments  SegmentFunc
+	env       interface{}
+	functions map[string]interface{}
+	clock     rule.Clock
+	location  *time.Location
+}
+
+func newOptions(opts []Option) options {
+	o := options{functions: make(map[string]interface{})}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithName sets the name of the rule being validated, so that a parent
+// extending it is reported as a cycle.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithResolver sets the resolver looking up the rules referenced or extended
+// by the rule, the parents are missing in ValidateRules without it.
+func WithResolver(resolver rule.Resolver) Option {
+	return func(o *options) {
+		o.resolver = resolver
+	}
+}
+
+// WithSegments sets the lookup of the segments used in the expressions as
+// segment.name or segment("name"), in addition to those under the
+// segments key of the rule, unless a custom CompileFunc is used.
+func WithSegments(lookup SegmentFunc) Option {
+	return func(o *options) {
+		o.segments = lookup
+	}
+}
+
+// WithEnv type checks the expressions and the let bindings against env, eg.
+// a struct or a map of sample values, unless a custom CompileFunc is used.
+func WithEnv(env interface{}) Option {
+	return func(o *options) {
+		o.env = env
+	}
+}
+
+// WithFunction makes fn available to the expressions as name, whatever the
+// type of the payload is, unless a custom CompileFunc is used. It replaces the
+// builtin function of the same name, eg. DaysAgo, and takes precedence over
+// the methods of the payload.
+func WithFunction(name string, fn interface{}) Option {
+	return func(o *options) {
+		o.functions[name] = fn
+	}
+}
+
+// WithFunctions calls WithFunction for each of the functions.
+func WithFunctions(functions map[string]interface{}) Option {
+	return func(o *options) {
+		for name, fn := range functions {
+			o.functions[name] = fn
+		}
+	}
+}
+
+// WithClock sets the clock of the time functions, eg. Now and IsToday,
+// time.Now by default. The clock of the context of rule.CalculateContext
+// overrides it.
+func WithClock(clock rule.Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithLocation sets the location of the time functions, time.Local by
+// default. The tz field of the payload and the location of the context of
+// rule.CalculateContext override it.
+func WithLocation(loc *time.Location) Option {
+	return func(o *options) {
+		o.location = loc
+	}
+}
+
+func ValidateRules(reader io.Reader, opts ...Option) error {
 	var tmp rule.Ruler
+	o := newOptions(opts)
 
 	value, err := io.ReadAll(reader)
 	if err != nil {
-		return &ErrInvalidRules{err.Error()}
+		return &ErrInvalidRules{detail: err.Error()}
 	}
 
 	c := koanf.New(".")
-	err = c.Load(rawbytes.Provider(value), kyaml.Parser())
+	err = c.Load(rawbytes.Provider(value), yamlParser{})
 	if err != nil {
-		return &ErrInvalidRules{err.Error()}
+		return &ErrInvalidRules{detail: err.Error()}
 	}
 	tmp, err = NewRuler(c.String("style"))
 	if err != nil {
-		return &ErrInvalidRules{err.Error()}
+		return &ErrInvalidRules{detail: err.Error()}
 	}
 	if err = tmp.Unmarshal(c); err != nil {
-		return &ErrInvalidRules{err.Error()}
+		return &ErrInvalidRules{detail: err.Error()}
+	}
+	if c.Bool("template") {
+		enableTemplates(tmp)
+	}
+	tmp = withExtends(tmp, c)
+	if err := compile(tmp, c, nil, o); err != nil {
+		return &ErrInvalidRules{detail: err.Error()}
+	}
+	if err := checkExtends(tmp, o); err != nil {
+		return &ErrInvalidRules{detail: err.Error(), Key: "extends"}
 	}
-	if err := tmp.Compile(); err != nil {
-		return &ErrInvalidRules{err.Error()}
+	if o.resolver != nil {
+		Resolve(tmp, o.resolver)
 	}
 	if err := runTests(tmp, c); err != nil {
-		return &ErrInvalidRules{err.Error()}
+		invalid := &ErrInvalidRules{detail: err.Error(), Key: "tests"}
+		var testErr *TestCaseError
+		if errors.As(err, &testErr) {
+			invalid.Index = testErr.Index
+		}
+		return invalid
 	}
 	if err := runSchemaValidation(tmp, c); err != nil {
-		return &ErrInvalidRules{err.Error()}
+		return &ErrInvalidRules{detail: err.Error(), Key: "def"}
+	}
+	if err := validateDefaultResult(c); err != nil {
+		return &ErrInvalidRules{detail: err.Error(), Key: "default_result"}
+	}
+	return nil
+}
+
+// DefaultResult returns the default_result of the rule document, which is
+// used in place of the result when the rule fails to calculate.
+func DefaultResult(reader io.Reader) (dto.Data, bool, error) {
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "reader is not valid")
+	}
+	c := koanf.New(".")
+	if err = c.Load(rawbytes.Provider(value), yamlParser{}); err != nil {
+		return nil, false, errors.Wrap(err, "cannot load yaml")
+	}
+	return defaultResult(c)
+}
+
+func defaultResult(c *koanf.Koanf) (dto.Data, bool, error) {
+	if !c.Exists("default_result") {
+		return nil, false, nil
+	}
+	var data dto.Data
+	if err := c.Unmarshal("default_result", &data); err != nil {
+		return nil, false, errors.Wrap(err, "invalid default_result")
+	}
+	if data == nil {
+		data = dto.Data{}
+	}
+	return convert(data), true, nil
+}
+
+// checkExtends follows the parents of the ruler, each of them must be found
+// by the resolver without returning to a rule visited.
+func checkExtends(ruler rule.Ruler, o options) error {
+	var path []string
+	visited := make(map[string]bool)
+	if o.name != "" {
+		path = append(path, o.name)
+		visited[o.name] = true
+	}
+	for parent := Extends(ruler); parent != ""; parent = Extends(ruler) {
+		path = append(path, parent)
+		if visited[parent] {
+			return fmt.Errorf("extends cycle %s", strings.Join(path, " -> "))
+		}
+		visited[parent] = true
+		if o.resolver != nil {
+			ruler = o.resolver.GetRuler(parent)
+		}
+		if o.resolver == nil || ruler == nil {
+			return fmt.Errorf("missing parent %s", parent)
+		}
 	}
 	return nil
 }
@@ -189,6 +466,22 @@ func runTests(ruler rule.Ruler, c *koanf.Koanf) error {
 	return nil
 }
 
+// validateDefaultResult checks the default_result against def if both exist.
+func validateDefaultResult(c *koanf.Koanf) error {
+	data, ok, err := defaultResult(c)
+	if err != nil || !ok || !c.Exists("def") {
+		return err
+	}
+	var schemaStruct map[string]interface{}
+	if err := c.Unmarshal("def", &schemaStruct); err != nil {
+		return errors.Wrap(err, "unable to unmarshal def")
+	}
+	if err := validateData(gojsonschema.NewGoLoader(schemaStruct), data); err != nil {
+		return errors.Wrap(err, "default_result does not match def")
+	}
+	return nil
+}
+
 func runSchemaValidation(ruler rule.Ruler, c *koanf.Koanf) error {
 	if !c.Exists("def") {
 		return nil
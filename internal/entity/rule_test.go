@@ -132,6 +132,41 @@ default:
 				assert.Error(t, err)
 			},
 		},
+		{
+			"with success default_result",
+			`
+def:
+  type: object
+  required:
+    - foo
+style: basic
+rule:
+  foo: bar
+default_result:
+  foo: baz`,
+			func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			"with failed default_result",
+			`
+def:
+  type: object
+  required:
+    - foo
+style: basic
+rule:
+  foo: bar
+default_result:
+  foot: baz`,
+			func(t *testing.T, err error) {
+				var invalid *ErrInvalidRules
+				if assert.ErrorAs(t, err, &invalid) {
+					assert.Equal(t, "default_result", invalid.Key)
+				}
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -141,3 +176,25 @@ default:
 		})
 	}
 }
+
+func TestDefaultResult(t *testing.T) {
+	data, ok, err := DefaultResult(strings.NewReader(`
+style: basic
+rule:
+  foo: bar
+default_result:
+  foo: baz
+  nested:
+    a: 1`))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "baz", data["foo"])
+	assert.Equal(t, map[string]interface{}{"a": 1}, data["nested"])
+
+	_, ok, err = DefaultResult(strings.NewReader(`
+style: basic
+rule:
+  foo: bar`))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
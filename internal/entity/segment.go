@@ -0,0 +1,120 @@
+package entity
+
+import (
+	"fmt"
+
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/parser"
+	"github.com/knadh/koanf"
+)
+
+// SegmentFunc returns the condition of the named segment.
+type SegmentFunc func(name string) (string, bool)
+
+// ErrUnknownSegment is returned when an expression references a segment not
+// defined.
+type ErrUnknownSegment struct {
+	Name string
+}
+
+func (e *ErrUnknownSegment) Error() string {
+	return fmt.Sprintf("unknown segment %s", e.Name)
+}
+
+// segmentFunc returns the lookup of the segments defined in the document and
+// by the option, the former take precedence. It returns nil if there is none.
+func segmentFunc(c *koanf.Koanf, o options) SegmentFunc {
+	local := c.StringMap("segments")
+	if len(local) == 0 {
+		return o.segments
+	}
+	return func(name string) (string, bool) {
+		if cond, ok := local[name]; ok {
+			return cond, true
+		}
+		if o.segments == nil {
+			return "", false
+		}
+		return o.segments(name)
+	}
+}
+
+// segmentPatch replaces the references to the segments in the expression
+// tree, segment.name or segment("name"), with the trees of their conditions,
+// which may reference other segments. Segments live in their own namespace,
+// so payload fields and let bindings are never taken as segments, and a
+// referenced segment must be defined.
+type segmentPatch struct {
+	lookup   SegmentFunc
+	visiting map[string]bool
+	err      error
+}
+
+func (p *segmentPatch) Enter(node *ast.Node) {}
+
+func (p *segmentPatch) Exit(node *ast.Node) {
+	if p.err != nil {
+		return
+	}
+	var name string
+	switch n := (*node).(type) {
+	case *ast.FunctionNode:
+		if n.Name != "segment" {
+			return
+		}
+		if len(n.Arguments) != 1 {
+			p.err = fmt.Errorf("segment takes the name of a segment")
+			return
+		}
+		arg, ok := n.Arguments[0].(*ast.StringNode)
+		if !ok {
+			p.err = fmt.Errorf("segment takes the name of a segment")
+			return
+		}
+		name = arg.Value
+	case *ast.PropertyNode:
+		ident, ok := n.Node.(*ast.IdentifierNode)
+		if !ok || ident.Value != "segment" {
+			return
+		}
+		name = n.Property
+	default:
+		return
+	}
+	if p.visiting[name] {
+		p.err = fmt.Errorf("segment %s references itself", name)
+		return
+	}
+	cond, ok := p.lookup(name)
+	if !ok {
+		p.err = &ErrUnknownSegment{Name: name}
+		return
+	}
+	tree, err := parser.Parse(cond)
+	if err != nil {
+		p.err = fmt.Errorf("segment %s: %w", name, err)
+		return
+	}
+	p.visiting[name] = true
+	ast.Walk(&tree.Node, p)
+	delete(p.visiting, name)
+	ast.Patch(node, tree.Node)
+}
+
+// parse parses the expression and expands the segments of lookup, every
+// segment is unknown if lookup is nil.
+func parse(s string, lookup SegmentFunc) (*parser.Tree, error) {
+	tree, err := parser.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if lookup == nil {
+		lookup = func(string) (string, bool) { return "", false }
+	}
+	p := &segmentPatch{lookup: lookup, visiting: make(map[string]bool)}
+	ast.Walk(&tree.Node, p)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return tree, nil
+}
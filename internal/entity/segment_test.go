@@ -0,0 +1,236 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GGXXLL/rule/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegments(t *testing.T) {
+	shared := map[string]string{
+		"paid": "paid == true",
+		"vip":  `segment.paid && level >= 5`,
+		"loop": `segment('loop')`,
+	}
+	lookup := func(name string) (string, bool) {
+		cond, ok := shared[name]
+		return cond, ok
+	}
+
+	cases := []struct {
+		name    string
+		rule    string
+		payload dto.Payload
+		asserts func(t *testing.T, data dto.Data, err error)
+	}{
+		{
+			"shared",
+			`
+style: advanced
+rule:
+  - if: segment("vip")
+    then:
+      sms: 1
+  - if: true
+    then:
+      sms: 0`,
+			dto.Payload{"paid": true, "level": 6},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"not matched",
+			`
+style: advanced
+rule:
+  - if: segment("vip")
+    then:
+      sms: 1
+  - if: true
+    then:
+      sms: 0`,
+			dto.Payload{"paid": true, "level": 4},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 0, data["sms"])
+			},
+		},
+		{
+			"local takes precedence",
+			`
+segments:
+  paid: "true"
+style: advanced
+rule:
+  - if: segment('vip') || !segment("paid")
+    then:
+      sms: 1`,
+			dto.Payload{"level": 5},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"property",
+			`
+style: advanced
+rule:
+  - if: segment.vip && level < 10
+    then:
+      sms: 1
+  - if: true
+    then:
+      sms: 0`,
+			dto.Payload{"paid": true, "level": 6},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"same name as a field",
+			`
+segments:
+  level: level >= 5
+style: advanced
+rule:
+  - if: level > 3 && segment.level
+    then:
+      sms: 1
+  - if: true
+    then:
+      sms: 0`,
+			dto.Payload{"level": 6},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"string literal",
+			`
+style: advanced
+rule:
+  - if: note == 'segment("vip")' || note == "vip"
+    then:
+      sms: 1
+  - if: true
+    then:
+      sms: 0`,
+			dto.Payload{"note": `segment("vip")`},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"same name as a let binding",
+			`
+segments:
+  total: total > 100
+style: advanced
+let:
+  total: price * count
+rule:
+  - if: total > 10 && !segment.total
+    then:
+      sms: 1
+  - if: true
+    then:
+      sms: 0`,
+			dto.Payload{"price": 5, "count": 3, "total": 1},
+			func(t *testing.T, data dto.Data, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["sms"])
+			},
+		},
+		{
+			"unknown",
+			`
+style: advanced
+rule:
+  - if: segment("new_user")
+    then:
+      sms: 1`,
+			nil,
+			func(t *testing.T, data dto.Data, err error) {
+				var unknown *ErrUnknownSegment
+				if assert.ErrorAs(t, err, &unknown) {
+					assert.Equal(t, "new_user", unknown.Name)
+				}
+			},
+		},
+		{
+			"unknown property",
+			`
+style: advanced
+rule:
+  - if: segment.new_user
+    then:
+      sms: 1`,
+			nil,
+			func(t *testing.T, data dto.Data, err error) {
+				var unknown *ErrUnknownSegment
+				if assert.ErrorAs(t, err, &unknown) {
+					assert.Equal(t, "new_user", unknown.Name)
+				}
+			},
+		},
+		{
+			"cycle",
+			`
+style: advanced
+rule:
+  - if: segment("loop")
+    then:
+      sms: 1`,
+			nil,
+			func(t *testing.T, data dto.Data, err error) {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), "segment loop references itself")
+				}
+			},
+		},
+	}
+
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ruler, err := NewRules(strings.NewReader(c.rule), WithSegments(lookup))
+			if err != nil {
+				c.asserts(t, nil, err)
+				return
+			}
+			data, err := ruler.Calculate(c.payload)
+			c.asserts(t, data, err)
+		})
+	}
+}
+
+func TestValidateRules_Segments(t *testing.T) {
+	err := ValidateRules(strings.NewReader(`
+segments:
+  foo: name == "foo"
+style: advanced
+rule:
+  - if: segment("foo")
+    then:
+      sms: 1`))
+	assert.NoError(t, err)
+
+	err = ValidateRules(strings.NewReader(`
+style: advanced
+rule:
+  - if: segment("foo")
+    then:
+      sms: 1`), WithSegments(func(name string) (string, bool) { return "", false }))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown segment foo")
+	}
+}
@@ -6,30 +6,34 @@ import (
 	"github.com/GGXXLL/rule"
 
 	"github.com/GGXXLL/rule/dto"
-	"github.com/fatih/structs"
 	"github.com/hashicorp/go-multierror"
 	"github.com/knadh/koanf"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
 	"github.com/xeipuuv/gojsonschema"
 )
 
 type SwitchRule struct {
-	style    string
-	by       string
-	cases    map[string]rule.Ruler
+	style string
+	by    string
+	// cases maps the case values to the index of branches.
+	cases map[string]int
+	// branches are the distinct rulers of cases, a ruler can serve multiple cases.
+	branches []rule.Ruler
 	fallback rule.Ruler
 }
 
 func NewSwitchRule() *SwitchRule {
 	return &SwitchRule{
 		style: "switch",
-		cases: make(map[string]rule.Ruler),
+		cases: make(map[string]int),
 	}
 }
 
 func (s *SwitchRule) ValidateWithSchema(schema gojsonschema.JSONLoader) error {
 	var err multierror.Error
-	for i := range s.cases {
-		errors := s.cases[i].ValidateWithSchema(schema)
+	for i := range s.branches {
+		errors := s.branches[i].ValidateWithSchema(schema)
 		if errors != nil {
 			err.Errors = append(err.Errors, errors)
 		}
@@ -56,15 +60,22 @@ func (s *SwitchRule) Unmarshal(reader *koanf.Koanf) (err error) {
 	s.by = reader.MustString("by")
 	cases := reader.Slices("rule")
 	for i := len(cases) - 1; i >= 0; i-- {
-		style := cases[i].String("style")
-		s.cases[cases[i].MustString("case")], err = NewRuler(style)
+		values, err := caseValues(cases[i].Get("case"))
 		if err != nil {
 			return err
 		}
-		err = s.cases[cases[i].MustString("case")].Unmarshal(cases[i])
+		ruler, err := NewRuler(cases[i].String("style"))
 		if err != nil {
 			return err
 		}
+		err = ruler.Unmarshal(cases[i])
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			s.cases[v] = i
+		}
+		s.branches = append([]rule.Ruler{ruler}, s.branches...)
 	}
 	style := reader.String("default.style")
 	s.fallback, err = NewRuler(style)
@@ -80,36 +91,80 @@ func (s *SwitchRule) Unmarshal(reader *koanf.Koanf) (err error) {
 }
 
 func (s *SwitchRule) Calculate(payload interface{}) (dto.Data, error) {
-	m, ok := payload.(dto.Payload)
-	if !ok {
-		m = structs.Map(payload)
+	return s.Explain(payload, nil)
+}
+
+func (s *SwitchRule) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	if trace != nil {
+		trace.Style = "switch"
+		trace.By = s.by
 	}
-	by, ok := m[s.by]
+	by, ok := valueOf(payload, s.by)
 	if !ok {
-		return nil, fmt.Errorf("switch by non-exist key %s", s.by)
+		return nil, &rule.EvalError{Condition: s.by, Kind: rule.ErrMissingKey, Err: fmt.Errorf("switch by non-exist key %s", s.by)}
 	}
-	byStr, ok := by.(string)
-	if !ok {
-		return nil, fmt.Errorf("can only switch by string type, got: %s", s.by)
+	byStr, err := cast.ToStringE(by)
+	if err != nil {
+		return nil, &rule.EvalError{Condition: s.by, Kind: rule.ErrKeyType, Err: errors.Wrap(err, "can only switch by scalar type")}
 	}
-	c, ok := s.cases[byStr]
+	if trace != nil {
+		trace.Value = by
+	}
+	i, ok := s.cases[byStr]
 	if !ok {
+		if trace != nil {
+			trace.Fallback = true
+		}
 		if s.fallback == nil {
 			return dto.Data{}, nil
 		}
-		return s.fallback.Calculate(payload)
+		data, err := explain(s.fallback, payload, trace.Child("default"))
+		return data, atPath(err, "default")
+	}
+	if trace != nil {
+		trace.Matched = true
+		trace.Case = byStr
 	}
-	return c.Calculate(payload)
+	data, err := explain(s.branches[i], payload, trace.Index("rule", i))
+	return data, atPath(err, fmt.Sprintf("rule[%d]", i))
 }
 
 func (s *SwitchRule) Compile() error {
-	for i := range s.cases {
-		if err := s.cases[i].Compile(); err != nil {
+	return s.CompileWithFunc(nil)
+}
+
+func (s *SwitchRule) CompileWithFunc(compileFunc rule.CompileFunc) error {
+	for i := range s.branches {
+		if err := compileChild(s.branches[i], compileFunc); err != nil {
 			return err
 		}
 	}
 	if s.fallback == nil {
 		return nil
 	}
-	return s.fallback.Compile()
+	return compileChild(s.fallback, compileFunc)
+}
+
+// caseValues returns the canonical string forms of a scalar case or a list of cases.
+func caseValues(c interface{}) ([]string, error) {
+	list, ok := c.([]interface{})
+	if !ok {
+		list = []interface{}{c}
+	}
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		if v == nil {
+			return nil, errors.New("missing case")
+		}
+		s, err := cast.ToStringE(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid case %v", v)
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+func (s *SwitchRule) children() []rule.Ruler {
+	return append(append([]rule.Ruler(nil), s.branches...), s.fallback)
 }
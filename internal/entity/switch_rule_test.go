@@ -214,3 +214,195 @@ default:
 	}
 
 }
+
+func TestSwitchRule_By(t *testing.T) {
+	type device struct {
+		Platform    string `json:"platform"`
+		VersionCode int
+	}
+	type user struct {
+		Device *device `json:"device"`
+		VIP    bool    `structs:"vip"`
+	}
+	cases := []struct {
+		name    string
+		yaml    string
+		payload interface{}
+		expect  func(*testing.T, error, dto.Data)
+	}{
+		{
+			"multi-value case",
+			`
+style: switch
+by: platform
+rule:
+  - case: [ios, ipados]
+    style: basic
+    rule:
+      i: 1
+  - case: android
+    style: basic
+    rule:
+      i: 2
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			dto.Payload{"platform": "ipados"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["i"])
+			},
+		},
+		{
+			"nested map",
+			`
+style: switch
+by: device.platform
+rule:
+  - case: [ios, ipados]
+    style: basic
+    rule:
+      i: 1
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			dto.Payload{"device": map[string]interface{}{"platform": "ios"}},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["i"])
+			},
+		},
+		{
+			"nested struct and int",
+			`
+style: switch
+by: device.VersionCode
+rule:
+  - case: [100, 101]
+    style: basic
+    rule:
+      i: 1
+  - case: 102
+    style: basic
+    rule:
+      i: 2
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			user{Device: &device{Platform: "ios", VersionCode: 102}},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 2, data["i"])
+			},
+		},
+		{
+			"int as string",
+			`
+style: switch
+by: version_code
+rule:
+  - case: 100
+    style: basic
+    rule:
+      i: 1
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			dto.Payload{"version_code": "100"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["i"])
+			},
+		},
+		{
+			"bool",
+			`
+style: switch
+by: vip
+rule:
+  - case: true
+    style: basic
+    rule:
+      i: 1
+  - case: false
+    style: basic
+    rule:
+      i: 2
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			&user{VIP: true},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, data["i"])
+			},
+		},
+		{
+			"missing nested key",
+			`
+style: switch
+by: device.platform
+rule:
+  - case: ios
+    style: basic
+    rule:
+      i: 1
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			user{},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			"non-scalar",
+			`
+style: switch
+by: device
+rule:
+  - case: ios
+    style: basic
+    rule:
+      i: 1
+default:
+  style: basic
+  rule:
+    i: 3
+`,
+			dto.Payload{"device": map[string]interface{}{"platform": "ios"}},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			ar := NewSwitchRule()
+			k := koanf.New(".")
+			err := k.Load(rawbytes.Provider([]byte(c.yaml)), yaml.Parser())
+			assert.NoError(t, err)
+			err = ar.Unmarshal(k)
+			assert.NoError(t, err)
+			err = ar.Compile()
+			assert.NoError(t, err)
+			result, err := ar.Calculate(c.payload)
+			c.expect(t, err, result)
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/antonmedv/expr/vm"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+)
+
+// valueNode is a compiled value of a then block that contains expressions.
+type valueNode interface {
+	render(payload interface{}, trace *rule.Trace) (interface{}, error)
+}
+
+type staticNode struct {
+	value interface{}
+}
+
+func (n staticNode) render(interface{}, *rule.Trace) (interface{}, error) {
+	return n.value, nil
+}
+
+type mapNode map[string]valueNode
+
+func (n mapNode) render(payload interface{}, trace *rule.Trace) (interface{}, error) {
+	m := make(map[string]interface{}, len(n))
+	for k, v := range n {
+		rendered, err := v.render(payload, trace)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = rendered
+	}
+	return m, nil
+}
+
+type listNode []valueNode
+
+func (n listNode) render(payload interface{}, trace *rule.Trace) (interface{}, error) {
+	l := make([]interface{}, len(n))
+	for i, v := range n {
+		rendered, err := v.render(payload, trace)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = rendered
+	}
+	return l, nil
+}
+
+// exprNode is a string which is exactly one expression, it renders to the
+// result of the expression with its own type.
+type exprNode struct {
+	source  string
+	program *vm.Program
+}
+
+func (n exprNode) render(payload interface{}, trace *rule.Trace) (interface{}, error) {
+	output, err := run(n.program, payload, trace)
+	if err != nil {
+		return nil, &rule.EvalError{Condition: n.source, Kind: rule.ErrExpression, Err: err}
+	}
+	return output, nil
+}
+
+// stringNode is a string with expressions embedded in text, it renders to
+// the concatenation of the parts.
+type stringNode []valueNode
+
+func (n stringNode) render(payload interface{}, trace *rule.Trace) (interface{}, error) {
+	var sb strings.Builder
+	for _, part := range n {
+		rendered, err := part.render(payload, trace)
+		if err != nil {
+			return nil, err
+		}
+		s, err := cast.ToStringE(rendered)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(s)
+	}
+	return sb.String(), nil
+}
+
+// compileData compiles the expressions in the values of data, the values
+// tagged with !expr and, if template is true, the templates in the strings, eg.
+// "${ 'Hello ' + name }". It returns nil if data has no expression.
+func compileData(data dto.Data, compileFunc rule.CompileFunc, template bool) (valueNode, error) {
+	if compileFunc == nil {
+		compileFunc = defaultCompileFunc
+	}
+	node, dynamic, err := compileValue("then", map[string]interface{}(data), compileFunc, template)
+	if err != nil || !dynamic {
+		return nil, err
+	}
+	return node, nil
+}
+
+// renderData renders the compiled then block.
+func renderData(node valueNode, payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	rendered, err := node.render(payload, trace)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(map[string]interface{}), nil
+}
+
+func compileValue(path string, v interface{}, compileFunc rule.CompileFunc, template bool) (valueNode, bool, error) {
+	switch x := v.(type) {
+	case map[string]interface{}, dto.Data:
+		m, _ := asMap(x)
+		node := make(mapNode, len(m))
+		dynamic := false
+		for k, sub := range m {
+			n, d, err := compileValue(path+"."+k, sub, compileFunc, template)
+			if err != nil {
+				return nil, false, err
+			}
+			node[k] = n
+			dynamic = dynamic || d
+		}
+		if !dynamic {
+			return staticNode{v}, false, nil
+		}
+		return node, true, nil
+	case []interface{}:
+		node := make(listNode, len(x))
+		dynamic := false
+		for i, sub := range x {
+			n, d, err := compileValue(fmt.Sprintf("%s[%d]", path, i), sub, compileFunc, template)
+			if err != nil {
+				return nil, false, err
+			}
+			node[i] = n
+			dynamic = dynamic || d
+		}
+		if !dynamic {
+			return staticNode{v}, false, nil
+		}
+		return node, true, nil
+	case expression:
+		node, err := compileExpr(path, string(x), compileFunc)
+		return node, err == nil, err
+	case string:
+		if template {
+			return compileString(path, x, compileFunc)
+		}
+	}
+	return staticNode{v}, false, nil
+}
+
+func compileString(path string, s string, compileFunc rule.CompileFunc) (valueNode, bool, error) {
+	parts, err := splitTemplate(s)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid template in %s", path)
+	}
+	if len(parts) == 1 && !parts[0].expr {
+		return staticNode{parts[0].text}, parts[0].text != s, nil
+	}
+	node := make(stringNode, 0, len(parts))
+	for _, part := range parts {
+		if !part.expr {
+			node = append(node, staticNode{part.text})
+			continue
+		}
+		n, err := compileExpr(path, part.text, compileFunc)
+		if err != nil {
+			return nil, false, err
+		}
+		node = append(node, n)
+	}
+	if len(node) == 1 {
+		// keep the type of the result if the whole string is an expression
+		return node[0], true, nil
+	}
+	return node, true, nil
+}
+
+func compileExpr(path string, source string, compileFunc rule.CompileFunc) (valueNode, error) {
+	program, err := compileFunc(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid expression in %s", path)
+	}
+	if program == nil {
+		return nil, fmt.Errorf("invalid expression in %s: %s", path, source)
+	}
+	return exprNode{source: source, program: program}, nil
+}
+
+// enableTemplates turns on the templates in the strings of the then blocks of
+// the ruler and its children, the rules it references or extends keep their
+// own setting.
+func enableTemplates(ruler rule.Ruler) {
+	walk(ruler, func(r rule.Ruler) {
+		switch x := r.(type) {
+		case *BasicRule:
+			x.template = true
+		case *AdvancedRuleItem:
+			x.template = true
+		case *RangeRule:
+			for _, b := range x.ranges {
+				b.template = true
+			}
+		case *RolloutRule:
+			for _, v := range x.variants {
+				v.template = true
+			}
+		}
+	})
+}
+
+type templatePart struct {
+	text string
+	expr bool
+}
+
+// splitTemplate splits s into text and ${ expression } parts, "$${" is an
+// escaped "${" and a "${" without its closing brace is text. Braces inside the
+// expression must be balanced, braces in quoted strings are ignored.
+func splitTemplate(s string) ([]templatePart, error) {
+	var (
+		parts []templatePart
+		text  strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], "$${") {
+			text.WriteString("${")
+			i += 2
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			text.WriteByte(s[i])
+			continue
+		}
+		end, ok := matchBrace(s, i+2)
+		if !ok {
+			text.WriteString(s[i:])
+			break
+		}
+		if text.Len() > 0 {
+			parts = append(parts, templatePart{text: text.String()})
+			text.Reset()
+		}
+		source := strings.TrimSpace(s[i+2 : end])
+		if source == "" {
+			return nil, errors.New("empty expression")
+		}
+		parts = append(parts, templatePart{text: source, expr: true})
+		i = end
+	}
+	if text.Len() > 0 || len(parts) == 0 {
+		parts = append(parts, templatePart{text: text.String()})
+	}
+	return parts, nil
+}
+
+// matchBrace returns the index of the brace closing the one before start, if
+// any.
+func matchBrace(s string, start int) (int, bool) {
+	depth := 0
+	var quote byte
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return i, true
+			}
+			depth--
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,223 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GGXXLL/rule/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	cases := []struct {
+		name    string
+		yaml    string
+		payload dto.Payload
+		expect  func(*testing.T, error, dto.Data)
+	}{
+		{
+			"advanced",
+			`
+style: advanced
+template: true
+rule:
+  - if: price > 0
+    then:
+      greeting: "${ 'Hello ' + name }"
+      discount: !expr price * 0.1
+      message: "Hi ${name}, you have ${ len(items) } items"
+      escaped: "$${name}"
+      unclosed: "${name"
+      nested:
+        list: ["${ price }", "static"]
+        static: 1
+`,
+			dto.Payload{"name": "foo", "price": 100, "items": []interface{}{1, 2}},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{
+					"greeting": "Hello foo",
+					"discount": 10.0,
+					"message":  "Hi foo, you have 2 items",
+					"escaped":  "${name}",
+					"unclosed": "${name",
+					"nested": map[string]interface{}{
+						"list":   []interface{}{100, "static"},
+						"static": 1,
+					},
+				}, data)
+			},
+		},
+		{
+			"basic",
+			`
+style: basic
+template: true
+rule:
+  name: ${ name }
+  map: "${ {'a': 1}.a }"
+`,
+			dto.Payload{"name": "foo"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{"name": "foo", "map": 1}, data)
+			},
+		},
+		{
+			"switch",
+			`
+style: switch
+template: true
+by: name
+rule:
+  - case: foo
+    style: basic
+    rule:
+      i: ${ i + 1 }
+default:
+  style: basic
+  rule:
+    i: 0
+`,
+			dto.Payload{"name": "foo", "i": 1},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{"i": 2}, data)
+			},
+		},
+		{
+			"templates disabled",
+			`
+style: advanced
+rule:
+  - if: price > 0
+    then:
+      greeting: "${ 'Hello ' + name }"
+      escaped: "$${name}"
+      discount: !expr price * 0.1
+      nested:
+        name: ${ name }
+`,
+			dto.Payload{"name": "foo", "price": 100},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{
+					"greeting": "${ 'Hello ' + name }",
+					"escaped":  "$${name}",
+					"discount": 10.0,
+					"nested":   map[string]interface{}{"name": "${ name }"},
+				}, data)
+			},
+		},
+		{
+			"child of a template",
+			`
+style: advanced
+template: true
+rule:
+  - if: true
+    child:
+      style: range
+      by: price
+      rule:
+        - lt: 10
+          then:
+            name: ${ name }
+      default:
+        style: basic
+        rule:
+          name: "default ${ name }"
+`,
+			dto.Payload{"name": "foo", "price": 100},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.NoError(t, err)
+				assert.Equal(t, dto.Data{"name": "default foo"}, data)
+			},
+		},
+		{
+			"runtime error",
+			`
+style: basic
+template: true
+rule:
+  name: ${ name.foo }
+`,
+			dto.Payload{"name": "foo"},
+			func(t *testing.T, err error, data dto.Data) {
+				assert.Error(t, err)
+			},
+		},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			r, err := NewRules(strings.NewReader(c.yaml))
+			if !assert.NoError(t, err) {
+				return
+			}
+			result, err := r.Calculate(c.payload)
+			c.expect(t, err, result)
+		})
+	}
+}
+
+func TestTemplate_Validate(t *testing.T) {
+	for _, s := range []string{
+		`
+style: advanced
+template: true
+rule:
+  - if: true
+    then:
+      name: ${ name + }
+`,
+		`
+style: basic
+rule:
+  name: !expr name +
+`,
+		`
+style: basic
+template: true
+rule:
+  name: ${}
+`,
+	} {
+		assert.Error(t, ValidateRules(strings.NewReader(s)))
+	}
+
+	// the strings are text unless the rule enables the templates
+	assert.NoError(t, ValidateRules(strings.NewReader(`
+style: basic
+rule:
+  name: ${ name + }
+`)))
+}
+
+func TestTemplate_ExprOnIf(t *testing.T) {
+	err := ValidateRules(strings.NewReader(`
+style: advanced
+rule:
+  - if: !expr price > 0
+    then:
+      a: 1
+`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "if can't be tagged with !expr")
+	}
+}
+
+func TestYamlParser(t *testing.T) {
+	out, err := yamlParser{}.Unmarshal([]byte(""))
+	assert.NoError(t, err)
+	assert.Empty(t, out)
+
+	out, err = yamlParser{}.Unmarshal([]byte("a: !expr b\nc: [!expr d, e]\nf: {g: !expr h}"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": expression("b"),
+		"c": []interface{}{expression("d"), "e"},
+		"f": map[string]interface{}{"g": expression("h")},
+	}, out)
+}
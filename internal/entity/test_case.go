@@ -1,13 +1,16 @@
 package entity
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/GGXXLL/rule"
 
 	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/internal/function"
 	"github.com/antonmedv/expr"
 	"github.com/pkg/errors"
 )
@@ -15,12 +18,15 @@ import (
 type Given struct {
 	Method string `json:"method" yaml:"method"`
 	URL    string `json:"url" yaml:"url"`
-	Body   string `json:"body" yaml:"url"`
+	Body   string `json:"body" yaml:"body"`
 }
 
 type TestCase struct {
 	Given  Given  `json:"given" yaml:"given"`
 	Expect string `json:"expect" yaml:"expect"`
+	// Now pins the time of the time functions, as a date, a date time in the
+	// location of the tz field of the payload, or RFC3339.
+	Now string `json:"now" yaml:"now"`
 }
 
 func (t *TestCase) applyDefaults() {
@@ -52,7 +58,16 @@ func (t TestCase) Asserts(ruler rule.Ruler, decoder Decoder) error {
 		return errors.Wrapf(err, "unable to decode querystring: %s", req.URL.RawQuery)
 	}
 
-	data, err := ruler.Calculate(payload)
+	ctx := context.Background()
+	if t.Now != "" {
+		now, err := t.now(payload)
+		if err != nil {
+			return errors.Wrap(err, "unable to parse \"now\"")
+		}
+		ctx = rule.WithClock(ctx, rule.FixedClock(now))
+	}
+
+	data, err := rule.CalculateContext(ctx, ruler, payload)
 	if err != nil {
 		return errors.Wrap(err, "unable to calculate payload")
 	}
@@ -71,13 +86,39 @@ func (t TestCase) Asserts(ruler rule.Ruler, decoder Decoder) error {
 	return nil
 }
 
+func (t TestCase) now(payload dto.Payload) (time.Time, error) {
+	loc := time.Local
+	if tz, ok := payload["tz"].(string); ok && tz != "" {
+		var err error
+		if loc, err = function.LoadLocation(tz); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return function.Parse(t.Now, loc)
+}
+
 type TestCases []TestCase
 
+// TestCaseError is returned by TestCases.Asserts, Index is the index of the
+// first failing test case.
+type TestCaseError struct {
+	Index int
+	Err   error
+}
+
+func (e *TestCaseError) Error() string {
+	return fmt.Sprintf("no.%d: %s", e.Index, e.Err)
+}
+
+func (e *TestCaseError) Unwrap() error {
+	return e.Err
+}
+
 func (t TestCases) Asserts(ruler rule.Ruler, decoder Decoder) error {
 	for i := range t {
 		err := t[i].Asserts(ruler, decoder)
 		if err != nil {
-			return errors.Wrapf(err, "no.%d", i)
+			return &TestCaseError{Index: i, Err: err}
 		}
 	}
 	return nil
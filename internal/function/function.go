@@ -0,0 +1,174 @@
+// Package function implements the builtin functions of the rule expressions.
+package function
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+const (
+	DateFormat     = "2006-01-02"
+	DateTimeFormat = "2006-01-02 15:04:05"
+)
+
+// Conversions are the builtin functions other than the time functions.
+var Conversions = map[string]interface{}{
+	"ToString": ToString,
+	"ToInt":    ToInt,
+}
+
+// Builtins returns the builtin functions by name, a new map for each call.
+// The time functions are the methods of t.
+func Builtins(t *Time) map[string]interface{} {
+	builtins := map[string]interface{}{
+		"Now":         t.Now,
+		"Date":        t.Date,
+		"DateTime":    t.DateTime,
+		"DaysAgo":     t.DaysAgo,
+		"HoursAgo":    t.HoursAgo,
+		"MinutesAgo":  t.MinutesAgo,
+		"IsBefore":    t.IsBefore,
+		"IsAfter":     t.IsAfter,
+		"IsBetween":   t.IsBetween,
+		"IsWeekday":   t.IsWeekday,
+		"IsWeekend":   t.IsWeekend,
+		"IsToday":     t.IsToday,
+		"IsHourRange": t.IsHourRange,
+	}
+	for name, fn := range Conversions {
+		builtins[name] = fn
+	}
+	return builtins
+}
+
+var locations sync.Map
+
+// LoadLocation is time.LoadLocation with a cache, as the payloads of many
+// evaluations name the same few locations.
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Parse parses s as a date, a date time or RFC3339, the first two in loc.
+func Parse(s string, loc *time.Location) (time.Time, error) {
+	switch len(s) {
+	case len(DateFormat):
+		return time.ParseInLocation(DateFormat, s, loc)
+	case len(DateTimeFormat):
+		return time.ParseInLocation(DateTimeFormat, s, loc)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse %q as %s, %s or RFC3339", s, DateFormat, DateTimeFormat)
+	}
+	return t, nil
+}
+
+// Time implements the time functions at a fixed now.
+type Time struct {
+	now time.Time
+	loc *time.Location
+}
+
+// NewTime returns the Time at now in loc, time.Local if loc is nil.
+func NewTime(now time.Time, loc *time.Location) *Time {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &Time{now: now.In(loc), loc: loc}
+}
+
+func (t *Time) parse(s string) time.Time {
+	parsed, err := Parse(s, t.loc)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func (t *Time) Now() time.Time {
+	return t.now
+}
+
+func (t *Time) Date(s string) time.Time {
+	return t.parse(s)
+}
+
+func (t *Time) DateTime(s string) time.Time {
+	return t.parse(s)
+}
+
+func (t *Time) DaysAgo(s string) int {
+	if s == "" {
+		return 0
+	}
+	return int(t.now.Sub(t.parse(s)).Hours() / 24)
+}
+
+func (t *Time) HoursAgo(s string) int {
+	if s == "" {
+		return 0
+	}
+	return int(t.now.Sub(t.parse(s)).Hours())
+}
+
+func (t *Time) MinutesAgo(s string) int {
+	if s == "" {
+		return 0
+	}
+	return int(t.now.Sub(t.parse(s)).Minutes())
+}
+
+func (t *Time) IsBefore(s string) bool {
+	return t.now.Before(t.parse(s))
+}
+
+func (t *Time) IsAfter(s string) bool {
+	return t.now.After(t.parse(s))
+}
+
+func (t *Time) IsBetween(begin string, end string) bool {
+	return t.IsAfter(begin) && t.IsBefore(end)
+}
+
+func (t *Time) IsWeekday(day int) bool {
+	return t.now.Weekday() == time.Weekday(day)
+}
+
+func (t *Time) IsWeekend() bool {
+	if weekday := t.now.Weekday(); weekday == 0 || weekday == 6 {
+		return true
+	}
+	return false
+}
+
+// IsToday reports whether s is on the date of now in the location.
+func (t *Time) IsToday(s string) bool {
+	y, m, d := t.parse(s).In(t.loc).Date()
+	ny, nm, nd := t.now.Date()
+	return y == ny && m == nm && d == nd
+}
+
+func (t *Time) IsHourRange(begin int, end int) bool {
+	now := t.now.Hour()
+	return now >= begin && now <= end
+}
+
+func ToString(str interface{}) string {
+	return cast.ToString(str)
+}
+
+func ToInt(int interface{}) int {
+	return cast.ToInt(int)
+}
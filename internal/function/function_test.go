@@ -0,0 +1,59 @@
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	shanghai, err := LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		s      string
+		expect time.Time
+	}{
+		{"date", "2022-01-01", time.Date(2022, 1, 1, 0, 0, 0, 0, shanghai)},
+		{"date time", "2022-01-01 10:30:00", time.Date(2022, 1, 1, 10, 30, 0, 0, shanghai)},
+		{"rfc3339", "2022-01-01T10:30:00Z", time.Date(2022, 1, 1, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, cc := range cases {
+		c := cc
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			parsed, err := Parse(c.s, shanghai)
+			assert.NoError(t, err)
+			assert.True(t, c.expect.Equal(parsed), "%s != %s", c.expect, parsed)
+		})
+	}
+
+	_, err = Parse("2022/01/01", shanghai)
+	assert.Error(t, err)
+}
+
+func TestTime(t *testing.T) {
+	shanghai, _ := LoadLocation("Asia/Shanghai")
+	// 2022-01-01 20:00 in UTC is 2022-01-02 04:00 in Shanghai, a Sunday
+	now := time.Date(2022, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	utc := NewTime(now, time.UTC)
+	assert.True(t, utc.IsToday("2022-01-01"))
+	assert.True(t, utc.IsHourRange(18, 22))
+	assert.True(t, utc.IsWeekday(int(time.Saturday)))
+
+	local := NewTime(now, shanghai)
+	assert.True(t, local.IsToday("2022-01-02"))
+	assert.True(t, local.IsToday("2022-01-01T20:00:00Z"))
+	assert.False(t, local.IsHourRange(18, 22))
+	assert.True(t, local.IsWeekday(int(time.Sunday)))
+	assert.True(t, local.IsWeekend())
+	assert.Equal(t, 4, local.HoursAgo("2022-01-02 00:00:00"))
+	assert.Equal(t, 1, local.DaysAgo("2022-01-01"))
+	assert.True(t, local.IsBetween("2022-01-02 00:00:00", "2022-01-02T00:00:00Z"))
+	assert.True(t, local.IsAfter("2022-01-01T19:59:00Z"))
+	assert.Equal(t, 0, local.MinutesAgo(""))
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/GGXXLL/rule/client"
+	"github.com/GGXXLL/rule/contract"
+	"github.com/GGXXLL/rule/dto"
+)
+
+// observedEngine observes the fallbacks of its tenanters.
+type observedEngine struct {
+	client.Engine
+	metrics Metrics
+}
+
+// NewEngine returns the client.Engine reporting each fallback to m, see
+// client.WithFallback. Wrap the repository of the engine with NewRepository
+// to observe the evaluations as well.
+func NewEngine(e client.Engine, m Metrics) client.Engine {
+	return &observedEngine{Engine: e, metrics: m}
+}
+
+func (e *observedEngine) Of(ruleName string) client.Tenanter {
+	return &observedTenanter{Tenanter: e.Engine.Of(ruleName), name: ruleName, metrics: e.metrics}
+}
+
+type observedTenanter struct {
+	client.Tenanter
+	name    string
+	metrics Metrics
+}
+
+func (t *observedTenanter) Payload(pl interface{}) (contract.ConfigAccessor, error) {
+	c, err := t.Tenanter.Payload(pl)
+	if err != nil {
+		return nil, err
+	}
+	if source := client.SourceOf(c); source != client.SourceRule {
+		t.metrics.ObserveFallback(t.name, source.String())
+	}
+	return c, nil
+}
+
+func (t *observedTenanter) WithDefault(data dto.Data) client.Tenanter {
+	return &observedTenanter{Tenanter: t.Tenanter.WithDefault(data), name: t.name, metrics: t.metrics}
+}
+
+func (t *observedTenanter) WithContext(ctx context.Context) client.Tenanter {
+	return &observedTenanter{Tenanter: t.Tenanter.WithContext(ctx), name: t.name, metrics: t.metrics}
+}
@@ -0,0 +1,138 @@
+// Package metrics measures the evaluations of rules. Wrap the rule.Repository
+// with NewRepository to observe every evaluation, including those of the
+// HTTP and gRPC servers, and the client.Engine with NewEngine to observe the
+// fallbacks. NewPrometheus adapts Metrics to the Prometheus client.
+package metrics
+
+import (
+	"time"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
+)
+
+// Outcome is the outcome of an evaluation.
+type Outcome string
+
+const (
+	// OutcomeMatch means a case, branch or condition of the rule matched.
+	OutcomeMatch Outcome = "match"
+	// OutcomeDefault means nothing matched and the default is used.
+	OutcomeDefault Outcome = "default"
+	// OutcomeError means the evaluation failed.
+	OutcomeError Outcome = "error"
+)
+
+// Metrics receives the measurements.
+type Metrics interface {
+	// ObserveEvaluation is called after each evaluation. branch is the case
+	// of a switch rule, the range of a range rule, the variant of a rollout
+	// rule such as rule[1], the matched item of an advanced rule such as
+	// rule[1] or merge if several items are merged, or default.
+	ObserveEvaluation(ruleName string, outcome Outcome, branch string, duration time.Duration)
+	// ObserveFallback is called when the client.Engine falls back, source is
+	// the string of client.Source.
+	ObserveFallback(ruleName string, source string)
+	// ObserveRules is called once with the func returning the current numbers
+	// of the loaded rules and of those failing to compile, to be called
+	// whenever they are reported, eg. when the metrics are scraped.
+	ObserveRules(rules func() (loaded, failed int))
+}
+
+// outcomeOf derives the outcome and the branch from the trace of the evaluation.
+func outcomeOf(trace *rule.Trace, err error) (Outcome, string) {
+	if err != nil {
+		return OutcomeError, ""
+	}
+	if trace == nil {
+		return OutcomeMatch, ""
+	}
+	if trace.Fallback || !trace.Matched {
+		return OutcomeDefault, "default"
+	}
+	if trace.Case != "" {
+		return OutcomeMatch, trace.Case
+	}
+	branch := ""
+	for _, c := range trace.Children {
+		if !c.Matched {
+			continue
+		}
+		if branch != "" {
+			return OutcomeMatch, "merge"
+		}
+		branch = c.Path
+	}
+	return OutcomeMatch, branch
+}
+
+// observedRepository observes the evaluations of its rulers.
+type observedRepository struct {
+	rule.Repository
+	metrics Metrics
+}
+
+// NewRepository returns the rule.Repository whose rulers report each
+// evaluation to m. The evaluations are traced to find the branch taken, see
+// rule.Explain. The numbers of rules are read from repo whenever m reports
+// them, see Metrics.ObserveRules, the failed ones if repo is a
+// rule.StatusRepository.
+func NewRepository(repo rule.Repository, m Metrics) rule.Repository {
+	var status rule.StatusRepository
+	rule.AsRepository(repo, &status)
+	m.ObserveRules(func() (int, int) {
+		if status == nil {
+			return repo.Count(), 0
+		}
+		return repo.Count(), status.Failed()
+	})
+	return &observedRepository{Repository: repo, metrics: m}
+}
+
+// Unwrap returns the observed repository, so that its optional interfaces
+// are found by rule.AsRepository.
+func (r *observedRepository) Unwrap() rule.Repository {
+	return r.Repository
+}
+
+func (r *observedRepository) GetRuler(ruleName string) rule.Ruler {
+	ruler := r.Repository.GetRuler(ruleName)
+	if ruler == nil {
+		return nil
+	}
+	return &observedRuler{Ruler: ruler, name: ruleName, metrics: r.metrics}
+}
+
+// observedRuler reports the evaluations of the rule.Ruler.
+type observedRuler struct {
+	rule.Ruler
+	name    string
+	metrics Metrics
+}
+
+func (r *observedRuler) Calculate(payload interface{}) (dto.Data, error) {
+	return r.Explain(payload, nil)
+}
+
+func (r *observedRuler) Explain(payload interface{}, trace *rule.Trace) (dto.Data, error) {
+	e, ok := r.Ruler.(rule.Explainer)
+	if !ok {
+		start := time.Now()
+		data, err := r.Ruler.Calculate(payload)
+		outcome, branch := outcomeOf(nil, err)
+		r.metrics.ObserveEvaluation(r.name, outcome, branch, time.Since(start))
+		return data, err
+	}
+	if !trace.Recording() {
+		shallow := rule.ShallowTrace()
+		if trace != nil {
+			shallow = shallow.WithContext(trace.Context())
+		}
+		trace = shallow
+	}
+	start := time.Now()
+	data, err := e.Explain(payload, trace)
+	outcome, branch := outcomeOf(trace, err)
+	r.metrics.ObserveEvaluation(r.name, outcome, branch, time.Since(start))
+	return data, err
+}
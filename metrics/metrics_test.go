@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/client"
+	"github.com/GGXXLL/rule/driver"
+	"github.com/GGXXLL/rule/dto"
+	"github.com/GGXXLL/rule/repository"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type evaluation struct {
+	rule    string
+	outcome Outcome
+	branch  string
+}
+
+type mockMetrics struct {
+	mu          sync.Mutex
+	evaluations []evaluation
+	fallbacks   []string
+	rules       func() (int, int)
+}
+
+func (m *mockMetrics) ObserveEvaluation(ruleName string, outcome Outcome, branch string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evaluations = append(m.evaluations, evaluation{ruleName, outcome, branch})
+}
+
+func (m *mockMetrics) ObserveFallback(ruleName string, source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallbacks = append(m.fallbacks, ruleName+" "+source)
+}
+
+func (m *mockMetrics) ObserveRules(rules func() (loaded, failed int)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = rules
+}
+
+func newRepository(t *testing.T) rule.Repository {
+	drv := driver.NewMemoryDriver()
+	drv.Put("switch", `
+style: switch
+by: platform
+rule:
+  - case: ios
+    style: basic
+    rule:
+      age: 1
+default:
+  style: basic
+  rule:
+    age: 2
+default_result:
+  age: 3`)
+	drv.Put("advanced", `
+style: advanced
+mode: merge
+rule:
+  - if: age > 1
+    then:
+      a: 1
+  - if: age > 2
+    then:
+      b: 1
+  - if: age > 3
+    then:
+      c: 1`)
+	drv.Put("rollout", `
+style: rollout
+by: uid
+rule:
+  - weight: 0
+    then:
+      a: 1
+  - weight: 100
+    then:
+      a: 2`)
+	drv.Put("invalid", `style: unknown`)
+	repo, err := repository.NewRepository(drv, repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestRepository(t *testing.T) {
+	m := &mockMetrics{}
+	repo := NewRepository(newRepository(t), m)
+	loaded, failed := m.rules()
+	assert.Equal(t, 3, loaded)
+	assert.Equal(t, 1, failed)
+
+	cases := []struct {
+		rule    string
+		payload dto.Payload
+		outcome Outcome
+		branch  string
+	}{
+		{"switch", dto.Payload{"platform": "ios"}, OutcomeMatch, "ios"},
+		{"switch", dto.Payload{"platform": "android"}, OutcomeDefault, "default"},
+		{"switch", dto.Payload{}, OutcomeError, ""},
+		{"advanced", dto.Payload{"age": 2}, OutcomeMatch, "rule[0]"},
+		{"advanced", dto.Payload{"age": 3}, OutcomeMatch, "merge"},
+		{"advanced", dto.Payload{"age": 0}, OutcomeDefault, "default"},
+		{"rollout", dto.Payload{"uid": 1}, OutcomeMatch, "rule[1]"},
+	}
+	var expected []evaluation
+	for _, c := range cases {
+		_, _ = rule.Calculate(repo.GetRuler(c.rule), c.payload)
+		expected = append(expected, evaluation{c.rule, c.outcome, c.branch})
+	}
+	assert.Equal(t, expected, m.evaluations)
+
+	// explaining is observed as well, and still traced
+	_, trace, err := rule.Explain(repo.GetRuler("switch"), dto.Payload{"platform": "ios"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ios", trace.Case)
+	assert.Len(t, m.evaluations, len(cases)+1)
+
+	assert.Nil(t, repo.GetRuler("invalid"))
+
+	// the optional interfaces of the observed repository are still found
+	var status rule.StatusRepository
+	if assert.True(t, rule.AsRepository(repo, &status)) {
+		assert.Equal(t, 1, status.Failed())
+	}
+	var history rule.HistoryRepository
+	assert.True(t, rule.AsRepository(repo, &history))
+}
+
+func TestEngine(t *testing.T) {
+	m := &mockMetrics{}
+	e, err := client.NewRuleEngine(
+		client.WithRepository(NewRepository(newRepository(t), m)),
+		client.WithLogger(log.NewNopLogger()),
+		client.WithFallback(client.FallbackDefaultResult),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = NewEngine(e, m)
+
+	r, err := e.Of("switch").Payload(dto.Payload{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.Int("age"))
+	r, err = e.Of("advanced").WithDefault(dto.Data{"a": 2}).Payload(dto.Payload{"age": "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Int("a"))
+	_, err = e.Of("switch").Payload(dto.Payload{"platform": "ios"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"switch default_result", "advanced default"}, m.fallbacks)
+	assert.Len(t, m.evaluations, 3)
+}
+
+func TestPrometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p, err := NewPrometheus(WithRegisterer(reg), WithNamespace("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository(newRepository(t), p)
+	_, _ = rule.Calculate(repo.GetRuler("switch"), dto.Payload{"platform": "ios"})
+	_, _ = rule.Calculate(repo.GetRuler("switch"), dto.Payload{"platform": "ios"})
+	_, _ = rule.Calculate(repo.GetRuler("switch"), dto.Payload{})
+	p.ObserveFallback("switch", client.SourceDefaultResult.String())
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP test_rule_evaluations_total The number of rule evaluations.
+# TYPE test_rule_evaluations_total counter
+test_rule_evaluations_total{branch="",outcome="error",rule="switch"} 1
+test_rule_evaluations_total{branch="ios",outcome="match",rule="switch"} 2
+# HELP test_rule_fallbacks_total The number of results falling back.
+# TYPE test_rule_fallbacks_total counter
+test_rule_fallbacks_total{rule="switch",source="default_result"} 1
+# HELP test_rule_loaded The number of loaded rules.
+# TYPE test_rule_loaded gauge
+test_rule_loaded 3
+# HELP test_rule_failed The number of rules failing to compile.
+# TYPE test_rule_failed gauge
+test_rule_failed 1
+`), "test_rule_evaluations_total", "test_rule_fallbacks_total", "test_rule_loaded", "test_rule_failed")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, testutil.CollectAndCount(p.duration))
+
+	_, err = NewPrometheus(WithRegisterer(reg), WithNamespace("test"))
+	assert.Error(t, err)
+}
+
+func TestPrometheus_Rules(t *testing.T) {
+	p, err := NewPrometheus(WithRegisterer(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, float64(0), testutil.ToFloat64(p.loaded))
+
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", "style: basic\nrule:\n  a: 1\n")
+	repo, err := repository.NewRepository(drv, repository.WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewRepository(repo, p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	// the gauges follow the changes without any evaluation
+	drv.Put("b", "style: basic\nrule:\n  b: 1\n")
+	drv.Put("c", "style: unknown")
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(p.loaded) == 2 && testutil.ToFloat64(p.failed) == 1
+	}, time.Second, 10*time.Millisecond)
+}
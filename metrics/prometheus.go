@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus implements Metrics with the Prometheus client:
+//
+//	rule_evaluations_total{rule, outcome, branch}          counter
+//	rule_evaluation_duration_seconds{rule, outcome}        histogram
+//	rule_fallbacks_total{rule, source}                     counter
+//	rule_loaded                                            gauge
+//	rule_failed                                            gauge
+//
+// The gauges are read from the repository when scraped.
+type Prometheus struct {
+	evaluations *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	fallbacks   *prometheus.CounterVec
+	loaded      prometheus.GaugeFunc
+	failed      prometheus.GaugeFunc
+
+	mu    sync.RWMutex
+	rules func() (loaded, failed int)
+}
+
+type PrometheusOption func(o *prometheusOptions)
+
+type prometheusOptions struct {
+	namespace  string
+	registerer prometheus.Registerer
+	buckets    []float64
+}
+
+// WithNamespace sets the namespace prefixing the metric names.
+func WithNamespace(namespace string) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.namespace = namespace
+	}
+}
+
+// WithRegisterer replace the prometheus.DefaultRegisterer
+func WithRegisterer(registerer prometheus.Registerer) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.registerer = registerer
+	}
+}
+
+// WithBuckets replace the prometheus.DefBuckets of the duration histogram
+func WithBuckets(buckets []float64) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.buckets = buckets
+	}
+}
+
+// NewPrometheus creates and registers the metrics.
+func NewPrometheus(opts ...PrometheusOption) (*Prometheus, error) {
+	o := prometheusOptions{
+		registerer: prometheus.DefaultRegisterer,
+		buckets:    prometheus.DefBuckets,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	p := &Prometheus{
+		evaluations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Subsystem: "rule",
+			Name:      "evaluations_total",
+			Help:      "The number of rule evaluations.",
+		}, []string{"rule", "outcome", "branch"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Subsystem: "rule",
+			Name:      "evaluation_duration_seconds",
+			Help:      "The latency of rule evaluations.",
+			Buckets:   o.buckets,
+		}, []string{"rule", "outcome"}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Subsystem: "rule",
+			Name:      "fallbacks_total",
+			Help:      "The number of results falling back.",
+		}, []string{"rule", "source"}),
+	}
+	p.loaded = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: o.namespace,
+		Subsystem: "rule",
+		Name:      "loaded",
+		Help:      "The number of loaded rules.",
+	}, func() float64 {
+		loaded, _ := p.count()
+		return float64(loaded)
+	})
+	p.failed = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: o.namespace,
+		Subsystem: "rule",
+		Name:      "failed",
+		Help:      "The number of rules failing to compile.",
+	}, func() float64 {
+		_, failed := p.count()
+		return float64(failed)
+	})
+	for _, c := range []prometheus.Collector{p.evaluations, p.duration, p.fallbacks, p.loaded, p.failed} {
+		if err := o.registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Prometheus) ObserveEvaluation(ruleName string, outcome Outcome, branch string, duration time.Duration) {
+	p.evaluations.WithLabelValues(ruleName, string(outcome), branch).Inc()
+	p.duration.WithLabelValues(ruleName, string(outcome)).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) ObserveFallback(ruleName string, source string) {
+	p.fallbacks.WithLabelValues(ruleName, source).Inc()
+}
+
+func (p *Prometheus) ObserveRules(rules func() (loaded, failed int)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = rules
+}
+
+func (p *Prometheus) count() (loaded, failed int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.rules == nil {
+		return 0, 0
+	}
+	return p.rules()
+}
@@ -1,10 +1,42 @@
 package repository
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
 	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/dto"
 )
 
 type Container struct {
 	RuleSet rule.Ruler
 	KV      *rule.KeyValue
+	// Hash is the sha256 of KV.Value in hex.
+	Hash string
+	// LoadedAt is the time the rule is compiled.
+	LoadedAt time.Time
+	// Version numbers the compiled values of the rule from 1.
+	Version int
+	// References are the names of the rules referenced by the rule.
+	References []string
+	// Segments are the names looked up as segments by the rule.
+	Segments []string
+	// DefaultResult is the default_result of the rule, nil if it has none.
+	DefaultResult dto.Data
+}
+
+// rejection is the latest value of a rule that fails to compile.
+type rejection struct {
+	KV  *rule.KeyValue
+	Err error
+	At  time.Time
+	// Segments are the names looked up as segments by the rule, including
+	// those missing.
+	Segments []string
+}
+
+func hashOf(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GGXXLL/rule"
+	"github.com/go-kit/log/level"
+)
+
+const defaultHistorySize = 10
+
+// WithHistorySize sets the number of the compiled versions kept for each
+// rule, 10 by default.
+func WithHistorySize(size int) Option {
+	return func(r *defaultRepository) {
+		if size < 1 {
+			size = 1
+		}
+		r.historySize = size
+	}
+}
+
+// history holds the compiled versions of a rule, the oldest first.
+type history struct {
+	versions []*Container
+	// pinned is the version rolled back to, 0 if not pinned.
+	pinned int
+}
+
+func (h *history) latest() *Container {
+	if len(h.versions) == 0 {
+		return nil
+	}
+	return h.versions[len(h.versions)-1]
+}
+
+// newer reports whether the value of c is delivered after the latest version,
+// a value redelivered by the driver is not. Only a newer revision counts, or a
+// different value if the driver has no revisions.
+func (h *history) newer(c *Container) bool {
+	l := h.latest()
+	if l == nil {
+		return true
+	}
+	if c.KV.Revision != 0 {
+		return c.KV.Revision > l.KV.Revision
+	}
+	return c.Hash != l.Hash
+}
+
+// replace swaps the kept version of c for c, eg. recompiled.
+func (h *history) replace(c *Container) {
+	for i, v := range h.versions {
+		if v.Version == c.Version {
+			h.versions[i] = c
+		}
+	}
+}
+
+// add appends c as the latest version and unpins the history.
+func (h *history) add(c *Container, size int) {
+	h.pinned = 0
+	l := h.latest()
+	if l != nil && !h.newer(c) {
+		c.Version = l.Version
+		h.versions[len(h.versions)-1] = c
+		return
+	}
+	c.Version = 1
+	if l != nil {
+		c.Version = l.Version + 1
+	}
+	h.versions = append(h.versions, c)
+	if len(h.versions) > size {
+		h.versions = append([]*Container(nil), h.versions[len(h.versions)-size:]...)
+	}
+}
+
+func (h *history) get(version int) *Container {
+	for _, c := range h.versions {
+		if c.Version == version {
+			return c
+		}
+	}
+	return nil
+}
+
+// record adds c to the history of its key, it must be called with the lock held.
+func (r *defaultRepository) record(c *Container) {
+	h, ok := r.histories[c.KV.Key]
+	if !ok {
+		h = &history{}
+		r.histories[c.KV.Key] = h
+	}
+	h.add(c, r.historySize)
+}
+
+func (r *defaultRepository) History(ruleName string) []rule.Version {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	h, ok := r.histories[ruleName]
+	if !ok {
+		return nil
+	}
+	versions := make([]rule.Version, 0, len(h.versions))
+	for _, c := range h.versions {
+		versions = append(versions, rule.Version{
+			Version:  c.Version,
+			Revision: c.KV.Revision,
+			Hash:     c.Hash,
+			Value:    string(c.KV.Value),
+			LoadedAt: c.LoadedAt,
+		})
+	}
+	return versions
+}
+
+func (r *defaultRepository) Rollback(ruleName string, version int) error {
+	r.rwLock.Lock()
+	h, ok := r.histories[ruleName]
+	if !ok {
+		r.rwLock.Unlock()
+		return fmt.Errorf("%w: %s", rule.ErrRuleNotFound, ruleName)
+	}
+	c := h.get(version)
+	if c == nil {
+		r.rwLock.Unlock()
+		return fmt.Errorf("%w: %s version %d", rule.ErrVersionNotFound, ruleName, version)
+	}
+	// the rules referenced by the old version may have changed since
+	if err := r.cycle(c); err != nil {
+		r.rwLock.Unlock()
+		return fmt.Errorf("cannot roll back %s to version %d: %w", ruleName, version, err)
+	}
+	r.containers[ruleName] = c
+	h.pinned = version
+	if c == h.latest() {
+		h.pinned = 0
+	}
+	r.rwLock.Unlock()
+
+	_ = level.Info(r.logger).Log("msg", fmt.Sprintf("配置已回滚 %s 至版本 %d", ruleName, version))
+	r.dispatch(context.Background(), rule.EventTypeUpdate, withType(c, rule.EventTypeUpdate))
+	return nil
+}
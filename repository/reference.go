@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GGXXLL/rule"
+)
+
+// missingParentError is returned when the parent extended by a rule is not loaded.
+type missingParentError struct {
+	parent string
+}
+
+func (e *missingParentError) Error() string {
+	return fmt.Sprintf("missing parent %s", e.parent)
+}
+
+// checkCycle returns an error if the references of c lead back to itself.
+func (r *defaultRepository) checkCycle(c *Container) error {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	return r.cycle(c)
+}
+
+// cycle is checkCycle, it must be called with the lock held.
+func (r *defaultRepository) cycle(c *Container) error {
+	key := c.KV.Key
+	visited := make(map[string]bool)
+	var visit func(path []string, refs []string) []string
+	visit = func(path []string, refs []string) []string {
+		for _, ref := range refs {
+			if ref == key {
+				return append(path, ref)
+			}
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+			if dep, ok := r.containers[ref]; ok {
+				if cycle := visit(append(path, ref), dep.References); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		return nil
+	}
+	if cycle := visit([]string{key}, c.References); cycle != nil {
+		return fmt.Errorf("%w %s", rule.ErrReferenceCycle, strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// dependents returns the rules referencing the rule directly or indirectly.
+func (r *defaultRepository) dependents(key string) []*Container {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	var result []*Container
+	visited := map[string]bool{key: true}
+	queue := []string{key}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, c := range r.containers {
+			if visited[c.KV.Key] || !references(c, name) {
+				continue
+			}
+			visited[c.KV.Key] = true
+			result = append(result, c)
+			queue = append(queue, c.KV.Key)
+		}
+	}
+	return result
+}
+
+func references(c *Container, name string) bool {
+	for _, ref := range c.References {
+		if ref == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch dispatches the event of the container, followed by the update
+// events of its dependents, whose results may change as well.
+func (r *defaultRepository) dispatch(ctx context.Context, eventType rule.EventType, c Container) {
+	if r.dispatcher == nil {
+		return
+	}
+	_ = r.dispatcher.Dispatch(ctx, eventType, c)
+	for _, d := range r.dependents(c.KV.Key) {
+		_ = r.dispatcher.Dispatch(ctx, rule.EventTypeUpdate, withType(d, rule.EventTypeUpdate))
+	}
+}
+
+// withType copies the container with the event type, leaving the kept one untouched.
+func withType(c *Container, eventType rule.EventType) Container {
+	kv := *c.KV
+	kv.Type = eventType
+	copied := *c
+	copied.KV = &kv
+	return copied
+}
+
+// retryChildren compiles the rejected rules waiting for the parent again.
+func (r *defaultRepository) retryChildren(ctx context.Context, parent string) {
+	r.rwLock.RLock()
+	var kvs []*rule.KeyValue
+	for _, rej := range r.rejected {
+		var missing *missingParentError
+		if errors.As(rej.Err, &missing) && missing.parent == parent {
+			kvs = append(kvs, rej.KV)
+		}
+	}
+	r.rwLock.RUnlock()
+	for _, kv := range kvs {
+		r.retry(ctx, kv)
+	}
+}
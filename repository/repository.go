@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/GGXXLL/rule/internal/entity"
-	"github.com/GGXXLL/rule/msg"
 
 	"github.com/GGXXLL/rule"
 	"github.com/GGXXLL/rule/contract"
+	"github.com/GGXXLL/rule/dto"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
@@ -23,8 +26,20 @@ type defaultRepository struct {
 	driver     rule.Driver
 	logger     log.Logger
 	containers map[string]*Container
+	rejected   map[string]*rejection
+	histories  map[string]*history
 	rwLock     sync.RWMutex
 	regexp     *regexp.Regexp
+	revision   int64
+
+	segmentPrefix string
+	segments      map[string]string
+	env           interface{}
+	functions     map[string]interface{}
+	clock         rule.Clock
+	location      *time.Location
+
+	historySize int
 
 	customNewRuleFuncMap map[string]rule.NewRulerFunc
 	customCompileFuncMap map[string]rule.CompileFunc
@@ -60,6 +75,42 @@ func WithCompileFuncMap(m map[string]rule.CompileFunc) Option {
 	}
 }
 
+// WithEnv type checks the expressions and the let bindings of the rules
+// against env, the rules using a custom CompileFunc are not affected.
+func WithEnv(env interface{}) Option {
+	return func(r *defaultRepository) {
+		r.env = env
+	}
+}
+
+// WithFunction makes fn available to the expressions of the rules as name,
+// whatever the type of the payload is. It replaces the builtin function of
+// the same name, eg. DaysAgo.
+func WithFunction(name string, fn interface{}) Option {
+	return func(r *defaultRepository) {
+		if r.functions == nil {
+			r.functions = make(map[string]interface{})
+		}
+		r.functions[name] = fn
+	}
+}
+
+// WithClock sets the clock of the time functions in the expressions of the
+// rules, time.Now by default.
+func WithClock(clock rule.Clock) Option {
+	return func(r *defaultRepository) {
+		r.clock = clock
+	}
+}
+
+// WithLocation sets the location of the time functions in the expressions of
+// the rules, time.Local by default. The tz field of the payload overrides it.
+func WithLocation(loc *time.Location) Option {
+	return func(r *defaultRepository) {
+		r.location = loc
+	}
+}
+
 func WithRuleFunc(f rule.NewRulerFunc) Option {
 	return func(r *defaultRepository) {
 		r.customNewRuleFunc = f
@@ -79,12 +130,21 @@ func WithLogger(l log.Logger) Option {
 	}
 }
 
+// NewRepository returns the Repository of the rules of the driver, it also
+// implements rule.ListRepository, rule.StatusRepository,
+// rule.HistoryRepository, rule.RevisionRepository and
+// rule.DefaultResultRepository.
 func NewRepository(driver rule.Driver, opts ...Option) (rule.Repository, error) {
 	var repo = &defaultRepository{
 		driver:     driver,
 		logger:     log.NewJSONLogger(os.Stdout),
 		containers: make(map[string]*Container),
+		rejected:   make(map[string]*rejection),
+		histories:  make(map[string]*history),
+		segments:   make(map[string]string),
 		rwLock:     sync.RWMutex{},
+
+		historySize: defaultHistorySize,
 	}
 
 	for _, opt := range opts {
@@ -94,26 +154,55 @@ func NewRepository(driver rule.Driver, opts ...Option) (rule.Repository, error)
 	// 第一次拉取配置
 	items, err := driver.All(context.Background())
 	if err != nil {
-		return nil, errors.Wrap(err, msg.ErrorRules)
+		return nil, &rule.DriverError{Op: "all", Err: err}
 	}
 
+	var pending []*rule.KeyValue
 	for _, item := range items {
+		repo.syncRevision(item.Revision)
+		// 条件片段先于规则加载
+		if repo.isSegment(item.Key) {
+			repo.setSegment(item)
+			continue
+		}
 		if repo.regexp != nil && !repo.regexp.MatchString(item.Key) {
 			continue
 		}
+		pending = append(pending, item)
+	}
 
-		c := Container{KV: item}
+	// 规则可能先于其 extends 的父规则加载，缺少父规则的规则在其他规则加载后重试
+	for len(pending) > 0 {
+		var waiting []*rule.KeyValue
+		for _, item := range pending {
+			c := Container{KV: item}
 
-		c.RuleSet, err = repo.generateRuler(&c)
-		if err != nil {
-			_ = level.Error(repo.logger).Log("msg", fmt.Sprintf("%s generate rule error", item.Key), "err", err)
-			continue
-		}
+			err = repo.compile(&c)
+			if err != nil {
+				repo.rejected[item.Key] = &rejection{KV: item, Err: err, At: time.Now(), Segments: c.Segments}
+				var missing *missingParentError
+				if errors.As(err, &missing) {
+					waiting = append(waiting, item)
+					continue
+				}
+				_ = level.Error(repo.logger).Log("msg", fmt.Sprintf("%s generate rule error", item.Key), "err", err)
+				continue
+			}
 
-		repo.containers[item.Key] = &c
-		if repo.dispatcher != nil {
-			_ = repo.dispatcher.Dispatch(context.Background(), item.Type, c)
+			repo.containers[item.Key] = &c
+			delete(repo.rejected, item.Key)
+			repo.record(&c)
+			if repo.dispatcher != nil {
+				_ = repo.dispatcher.Dispatch(context.Background(), rule.EventTypeCreate, withType(&c, rule.EventTypeCreate))
+			}
 		}
+		if len(waiting) == len(pending) {
+			for _, item := range waiting {
+				_ = level.Error(repo.logger).Log("msg", fmt.Sprintf("%s generate rule error", item.Key), "err", repo.rejected[item.Key].Err)
+			}
+			break
+		}
+		pending = waiting
 	}
 
 	_ = level.Info(repo.logger).Log("msg", fmt.Sprintf("%d rules have been added", len(repo.containers)))
@@ -141,6 +230,29 @@ func (r *defaultRepository) getCustomCompileFunc(name string) rule.CompileFunc {
 	return nil
 }
 
+// compile generates the ruler of the container and resolves its references
+// through the repository.
+func (r *defaultRepository) compile(c *Container) (err error) {
+	c.RuleSet, err = r.generateRuler(c)
+	if err != nil {
+		return err
+	}
+	c.References = entity.References(c.RuleSet)
+	if err = r.checkCycle(c); err != nil {
+		return err
+	}
+	if parent := entity.Extends(c.RuleSet); parent != "" && r.GetRuler(parent) == nil {
+		return &missingParentError{parent: parent}
+	}
+	entity.Resolve(c.RuleSet, r)
+	if c.DefaultResult, _, err = entity.DefaultResult(bytes.NewReader(c.KV.Value)); err != nil {
+		// the rule is still served, only the fallback is missing
+		_ = level.Warn(r.logger).Log("msg", fmt.Sprintf("%s default_result error", c.KV.Key), "err", err)
+	}
+	c.Hash, c.LoadedAt = hashOf(c.KV.Value), time.Now()
+	return nil
+}
+
 func (r *defaultRepository) generateRuler(c *Container) (ruler rule.Ruler, err error) {
 	reader := bytes.NewReader(c.KV.Value)
 	if customNewRuleFunc := r.getCustomNewRuleFunc(c.KV.Key); customNewRuleFunc != nil {
@@ -149,12 +261,12 @@ func (r *defaultRepository) generateRuler(c *Container) (ruler rule.Ruler, err e
 			return nil, errors.New("invalid custom NewRuleFunc")
 		}
 	} else if customCompileFunc := r.getCustomCompileFunc(c.KV.Key); customCompileFunc != nil {
-		ruler, err = entity.NewCustomRules(reader, customCompileFunc)
+		ruler, err = entity.NewCustomRules(reader, customCompileFunc, r.options(c)...)
 		if err != nil {
 			return nil, errors.New("invalid custom CompileFunc")
 		}
 	} else {
-		ruler, err = entity.NewRules(reader)
+		ruler, err = entity.NewRules(reader, append(r.options(c), entity.WithEnv(r.env))...)
 		if err != nil {
 			return nil, err
 		}
@@ -162,6 +274,16 @@ func (r *defaultRepository) generateRuler(c *Container) (ruler rule.Ruler, err e
 	return
 }
 
+// options returns the options compiling the rule of the container.
+func (r *defaultRepository) options(c *Container) []entity.Option {
+	return []entity.Option{
+		entity.WithSegments(r.segmentFunc(c)),
+		entity.WithFunctions(r.functions),
+		entity.WithClock(r.clock),
+		entity.WithLocation(r.location),
+	}
+}
+
 func (r *defaultRepository) GetRuler(ruleName string) rule.Ruler {
 	r.rwLock.RLock()
 	defer r.rwLock.RUnlock()
@@ -184,56 +306,235 @@ func (r *defaultRepository) Watch(ctx context.Context) (err error) {
 	ch := r.driver.Watch(ctx)
 	for {
 		select {
-		case kv := <-ch:
+		case kv, ok := <-ch:
+			if !ok {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return &rule.DriverError{Op: "watch", Err: errors.New("channel closed")}
+			}
 			if kv.Err != nil {
-				return kv.Err
+				return &rule.DriverError{Op: "watch", Err: kv.Err}
+			}
+			r.syncRevision(kv.Revision)
+			if r.isSegment(kv.Key) {
+				r.putSegment(ctx, kv)
+				continue
 			}
 			// 匹配正则监听
 			if r.regexp != nil && !r.regexp.MatchString(kv.Key) {
 				continue
 			}
-			c := Container{KV: kv}
 			if kv.Type == rule.EventTypeDelete || len(kv.Value) == 0 {
-				r.deleteRuleSetByDbKey(kv.Key)
-				if r.dispatcher != nil {
-					_ = r.dispatcher.Dispatch(ctx, kv.Type, Container{KV: kv})
+				if !r.deleteRuleSetByDbKey(kv.Key) {
+					continue
 				}
+				kv.Type = rule.EventTypeDelete
+				r.dispatch(ctx, kv.Type, Container{KV: kv})
+				_ = level.Info(r.logger).Log("msg", fmt.Sprintf("配置已删除 %s", kv.Key))
 				continue
 			}
-			c.RuleSet, err = r.generateRuler(&c)
-			if err != nil {
-				_ = level.Error(r.logger).Log("msg", fmt.Sprintf("%s generate rule error", kv.Key), "err", err)
-				continue
-			}
-			r.updateRuleSet(&c)
-			if r.dispatcher != nil {
-				_ = r.dispatcher.Dispatch(ctx, kv.Type, c)
-			}
-			_ = level.Info(r.logger).Log("msg", fmt.Sprintf("配置已更新 %s", kv.Key))
+			r.put(ctx, kv)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
+// put compiles the value from the driver and serves it.
+func (r *defaultRepository) put(ctx context.Context, kv *rule.KeyValue) {
+	c := Container{KV: kv}
+	if err := r.compile(&c); err != nil {
+		_ = level.Error(r.logger).Log("msg", fmt.Sprintf("%s generate rule error", kv.Key), "err", err)
+		r.setRejected(&c, err)
+		return
+	}
+	existed, applied := r.setRuleSet(&c)
+	if !applied {
+		_ = level.Info(r.logger).Log("msg", fmt.Sprintf("配置已回滚，忽略重复的版本 %s", kv.Key))
+		return
+	}
+	eventType := rule.EventTypeCreate
+	if existed {
+		eventType = rule.EventTypeUpdate
+		_ = level.Info(r.logger).Log("msg", fmt.Sprintf("配置已更新 %s", kv.Key))
+	} else {
+		_ = level.Info(r.logger).Log("msg", fmt.Sprintf("配置已新增 %s", kv.Key))
+	}
+	r.dispatch(ctx, eventType, withType(&c, eventType))
+	r.retryChildren(ctx, kv.Key)
+}
+
+// retry compiles the value of the rule again once a segment or the parent it
+// uses changes: the served value in place, see recompile, or else the value
+// rejected as a new one from the driver.
+func (r *defaultRepository) retry(ctx context.Context, kv *rule.KeyValue) {
+	r.rwLock.RLock()
+	served, ok := r.containers[kv.Key]
+	r.rwLock.RUnlock()
+	if ok && served.KV == kv {
+		r.recompile(ctx, served)
+		return
+	}
+	r.put(ctx, kv)
+}
+
+// recompile compiles the served value of the rule again, which keeps its
+// version and stays pinned if rolled back to.
+func (r *defaultRepository) recompile(ctx context.Context, served *Container) {
+	c := Container{KV: served.KV}
+	if err := r.compile(&c); err != nil {
+		_ = level.Error(r.logger).Log("msg", fmt.Sprintf("%s generate rule error", c.KV.Key), "err", err)
+		r.setRejected(&c, err)
+		return
+	}
+	r.rwLock.Lock()
+	if r.containers[c.KV.Key] != served {
+		// replaced in the meantime
+		r.rwLock.Unlock()
+		return
+	}
+	c.Version = served.Version
+	r.containers[c.KV.Key] = &c
+	delete(r.rejected, c.KV.Key)
+	if h, ok := r.histories[c.KV.Key]; ok {
+		h.replace(&c)
+	}
+	r.rwLock.Unlock()
+	_ = level.Info(r.logger).Log("msg", fmt.Sprintf("配置已重新编译 %s", c.KV.Key))
+	r.dispatch(ctx, rule.EventTypeUpdate, withType(&c, rule.EventTypeUpdate))
+	r.retryChildren(ctx, c.KV.Key)
+}
+
+func (r *defaultRepository) DefaultResult(ruleName string) (dto.Data, bool) {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	c, ok := r.containers[ruleName]
+	if !ok || c.DefaultResult == nil {
+		return nil, false
+	}
+	return c.DefaultResult, true
+}
+
 func (r *defaultRepository) Count() int {
 	r.rwLock.RLock()
 	defer r.rwLock.RUnlock()
 	return len(r.containers)
 }
 
-func (r *defaultRepository) updateRuleSet(c *Container) bool {
+func (r *defaultRepository) Names() []string {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	names := make([]string, 0, len(r.containers))
+	for name := range r.containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *defaultRepository) Failed() int {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	return len(r.rejected)
+}
+
+func (r *defaultRepository) Status(ruleName string) (rule.Status, bool) {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	return r.status(ruleName)
+}
+
+func (r *defaultRepository) Statuses() []rule.Status {
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	names := make([]string, 0, len(r.containers)+len(r.rejected))
+	for name := range r.containers {
+		names = append(names, name)
+	}
+	for name := range r.rejected {
+		if _, ok := r.containers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	statuses := make([]rule.Status, 0, len(names))
+	for _, name := range names {
+		s, _ := r.status(name)
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// status must be called with the read lock held.
+func (r *defaultRepository) status(ruleName string) (rule.Status, bool) {
+	c, active := r.containers[ruleName]
+	rej, rejected := r.rejected[ruleName]
+	if !active && !rejected {
+		return rule.Status{}, false
+	}
+	s := rule.Status{Name: ruleName, State: rule.LoadStateActive}
+	if active {
+		s.Version = c.Version
+		s.Revision = c.KV.Revision
+		s.Hash = c.Hash
+		s.UpdatedAt = c.LoadedAt
+	}
+	if h, ok := r.histories[ruleName]; ok {
+		s.Pinned = h.pinned != 0
+	}
+	if rejected {
+		s.State = rule.LoadStateRejected
+		s.RejectedRevision = rej.KV.Revision
+		s.Rejected = string(rej.KV.Value)
+		s.Error = rej.Err.Error()
+		s.UpdatedAt = rej.At
+	}
+	return s, true
+}
+
+func (r *defaultRepository) Revision() int64 {
+	return atomic.LoadInt64(&r.revision)
+}
+
+func (r *defaultRepository) syncRevision(rev int64) {
+	for {
+		cur := atomic.LoadInt64(&r.revision)
+		if rev <= cur || atomic.CompareAndSwapInt64(&r.revision, cur, rev) {
+			return
+		}
+	}
+}
+
+// setRuleSet adds or replaces the container, existed reports whether the key
+// already existed. The container is not applied if the rule is rolled back and
+// the value is not newer than the latest version.
+func (r *defaultRepository) setRuleSet(c *Container) (existed, applied bool) {
 	r.rwLock.Lock()
 	defer r.rwLock.Unlock()
-	if _, ok := r.containers[c.KV.Key]; ok {
-		r.containers[c.KV.Key] = c
-		return true
+	if h, ok := r.histories[c.KV.Key]; ok && h.pinned != 0 && !h.newer(c) {
+		return true, false
 	}
-	return false
+	_, ok := r.containers[c.KV.Key]
+	r.containers[c.KV.Key] = c
+	delete(r.rejected, c.KV.Key)
+	r.record(c)
+	return ok, true
+}
+
+func (r *defaultRepository) setRejected(c *Container, err error) {
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+	r.rejected[c.KV.Key] = &rejection{KV: c.KV, Err: err, At: time.Now(), Segments: c.Segments}
 }
 
-func (r *defaultRepository) deleteRuleSetByDbKey(key string) {
+// deleteRuleSetByDbKey returns true if the key existed.
+func (r *defaultRepository) deleteRuleSetByDbKey(key string) bool {
 	r.rwLock.Lock()
 	defer r.rwLock.Unlock()
+	_, ok := r.containers[key]
 	delete(r.containers, key)
+	delete(r.rejected, key)
+	delete(r.histories, key)
+	return ok
 }
@@ -2,8 +2,13 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"io"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/antonmedv/expr"
 	"github.com/antonmedv/expr/vm"
@@ -11,6 +16,8 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/contract"
+	"github.com/GGXXLL/rule/driver"
 	"github.com/GGXXLL/rule/dto"
 )
 
@@ -125,3 +132,591 @@ func TestRepository(t *testing.T) {
 
 	cancel()
 }
+
+type event struct {
+	topic interface{}
+	key   string
+}
+
+type mockDispatcher struct {
+	sync.Mutex
+	events []event
+}
+
+func (d *mockDispatcher) Dispatch(ctx context.Context, topic interface{}, payload interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+	d.events = append(d.events, event{topic: topic, key: payload.(Container).KV.Key})
+	return nil
+}
+
+func (d *mockDispatcher) Subscribe(listener contract.Listener) {}
+
+func (d *mockDispatcher) Events() []event {
+	d.Lock()
+	defer d.Unlock()
+	return append([]event(nil), d.events...)
+}
+
+func TestRepository_Watch(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", `
+style: basic
+rule:
+  age: 1
+`)
+	dispatcher := &mockDispatcher{}
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()), WithDispatcher(dispatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, repo.Count())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	// created after start
+	drv.Put("b", `
+style: basic
+rule:
+  age: 2
+`)
+	// updated
+	drv.Put("a", `
+style: basic
+rule:
+  age: 3
+`)
+	// invalid rule is not registered
+	drv.Put("c", `
+style: unknown
+`)
+	drv.Delete("a")
+	// deleting an unknown key is a no-op
+	drv.Delete("d")
+	drv.Put("e", `
+style: basic
+rule:
+  age: 5
+`)
+
+	assert.Eventually(t, func() bool {
+		return repo.GetRuler("e") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 2, repo.Count())
+	assert.Equal(t, []string{"b", "e"}, repo.Names())
+	assert.Equal(t, 1, repo.Failed())
+	assert.Equal(t, int64(7), repo.Revision())
+	assert.Nil(t, repo.GetRuler("a"))
+	assert.Nil(t, repo.GetRuler("c"))
+	d, err := repo.GetRuler("b").Calculate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d["age"])
+
+	assert.Equal(t, []event{
+		{rule.EventTypeCreate, "a"},
+		{rule.EventTypeCreate, "b"},
+		{rule.EventTypeUpdate, "a"},
+		{rule.EventTypeDelete, "a"},
+		{rule.EventTypeCreate, "e"},
+	}, dispatcher.Events())
+}
+
+type failingDriver struct {
+	mockDriver
+}
+
+func (d *failingDriver) All(ctx context.Context) ([]*rule.KeyValue, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestNewRepository_DriverError(t *testing.T) {
+	_, err := NewRepository(&failingDriver{}, WithLogger(log.NewNopLogger()))
+	var driverErr *rule.DriverError
+	if assert.True(t, errors.As(err, &driverErr)) {
+		assert.Equal(t, "all", driverErr.Op)
+	}
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func TestRepository_Status(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", `
+style: basic
+rule:
+  age: 1
+`)
+	drv.Put("b", `style: unknown`)
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := repo.Status("a")
+	assert.True(t, ok)
+	assert.Equal(t, rule.LoadStateActive, s.State)
+	assert.Equal(t, int64(1), s.Revision)
+	assert.Equal(t, hashOf(repo.GetRaw("a")), s.Hash)
+	assert.Empty(t, s.Error)
+
+	s, ok = repo.Status("b")
+	assert.True(t, ok)
+	assert.Equal(t, rule.LoadStateRejected, s.State)
+	assert.Equal(t, int64(0), s.Revision)
+	assert.Equal(t, int64(2), s.RejectedRevision)
+	assert.Equal(t, "style: unknown", s.Rejected)
+	assert.NotEmpty(t, s.Error)
+
+	_, ok = repo.Status("c")
+	assert.False(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	// the previous value of a is still served
+	drv.Put("a", `style: unknown`)
+	// b is fixed
+	drv.Put("b", `
+style: basic
+rule:
+  age: 2
+`)
+	assert.Eventually(t, func() bool {
+		return repo.GetRuler("b") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	statuses := repo.Statuses()
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, "a", statuses[0].Name)
+		assert.Equal(t, rule.LoadStateRejected, statuses[0].State)
+		assert.Equal(t, int64(1), statuses[0].Revision)
+		assert.Equal(t, int64(3), statuses[0].RejectedRevision)
+		assert.Equal(t, "b", statuses[1].Name)
+		assert.Equal(t, rule.LoadStateActive, statuses[1].State)
+		assert.Equal(t, int64(4), statuses[1].Revision)
+		assert.Empty(t, statuses[1].Error)
+	}
+	assert.Equal(t, 1, repo.Failed())
+
+	drv.Delete("a")
+	assert.Eventually(t, func() bool {
+		return repo.Failed() == 0
+	}, time.Second, 10*time.Millisecond)
+	_, ok = repo.Status("a")
+	assert.False(t, ok)
+}
+
+func TestRepository_Rollback(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", `
+style: basic
+rule:
+  age: 1
+`)
+	dispatcher := &mockDispatcher{}
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()), WithDispatcher(dispatcher), WithHistorySize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	age := func() interface{} {
+		d, err := repo.GetRuler("a").Calculate(nil)
+		assert.NoError(t, err)
+		return d["age"]
+	}
+	put := func(value string, expect int) {
+		drv.Put("a", value)
+		assert.Eventually(t, func() bool {
+			return age() == expect
+		}, time.Second, 10*time.Millisecond)
+	}
+	put("style: basic\nrule:\n  age: 2\n", 2)
+	put("style: basic\nrule:\n  age: 3\n", 3)
+
+	// the oldest version is dropped
+	history := repo.History("a")
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, 2, history[0].Version)
+		assert.Equal(t, int64(2), history[0].Revision)
+		assert.Equal(t, "style: basic\nrule:\n  age: 2\n", history[0].Value)
+		assert.Equal(t, 3, history[1].Version)
+	}
+	assert.Nil(t, repo.History("b"))
+
+	assert.True(t, errors.Is(repo.Rollback("a", 1), rule.ErrVersionNotFound))
+	assert.True(t, errors.Is(repo.Rollback("b", 1), rule.ErrRuleNotFound))
+
+	assert.NoError(t, repo.Rollback("a", 2))
+	assert.Equal(t, 2, age())
+	s, _ := repo.Status("a")
+	assert.True(t, s.Pinned)
+	assert.Equal(t, 2, s.Version)
+	assert.Equal(t, int64(2), s.Revision)
+
+	// a newer value unpins the rule
+	put("style: basic\nrule:\n  age: 4\n", 4)
+	s, _ = repo.Status("a")
+	assert.False(t, s.Pinned)
+	assert.Equal(t, 4, s.Version)
+
+	// rolling back to the latest version unpins the rule
+	assert.NoError(t, repo.Rollback("a", 3))
+	assert.NoError(t, repo.Rollback("a", 4))
+	s, _ = repo.Status("a")
+	assert.False(t, s.Pinned)
+
+	events := dispatcher.Events()
+	assert.Equal(t, []event{
+		{rule.EventTypeCreate, "a"},
+		{rule.EventTypeUpdate, "a"},
+		{rule.EventTypeUpdate, "a"},
+		{rule.EventTypeUpdate, "a"},
+		{rule.EventTypeUpdate, "a"},
+		{rule.EventTypeUpdate, "a"},
+		{rule.EventTypeUpdate, "a"},
+	}, events)
+}
+
+func TestRepository_DefaultResult(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", "style: basic\nrule:\n  age: 1\ndefault_result:\n  age: 2\n")
+	drv.Put("b", "style: basic\nrule:\n  age: 1\n")
+	drv.Put("c", "style: basic\nrule:\n  age: 1\ndefault_result: 2\n")
+	repo, err := NewRepository(drv, WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr := repo.(rule.DefaultResultRepository)
+
+	d, ok := dr.DefaultResult("a")
+	assert.True(t, ok)
+	assert.Equal(t, dto.Data{"age": 2}, d)
+	_, ok = dr.DefaultResult("b")
+	assert.False(t, ok)
+	_, ok = dr.DefaultResult("missing")
+	assert.False(t, ok)
+
+	// an invalid default_result does not reject the rule
+	assert.NotNil(t, repo.GetRuler("c"))
+	_, ok = dr.DefaultResult("c")
+	assert.False(t, ok)
+}
+
+func TestRepository_RollbackCycle(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", "style: ref\nname: b\n")
+	drv.Put("b", "style: basic\nrule:\n  age: 1\n")
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	drv.Put("a", "style: basic\nrule:\n  age: 2\n")
+	drv.Put("b", "style: ref\nname: a\n")
+	assert.Eventually(t, func() bool {
+		d, err := repo.GetRuler("b").Calculate(nil)
+		return err == nil && d["age"] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// a referencing b again would be a cycle
+	assert.True(t, errors.Is(repo.Rollback("a", 1), rule.ErrReferenceCycle))
+	d, err := repo.GetRuler("b").Calculate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d["age"])
+}
+
+func TestHistory(t *testing.T) {
+	newContainer := func(value string, revision int64) *Container {
+		return &Container{KV: &rule.KeyValue{Key: "a", Value: []byte(value), Revision: revision}, Hash: hashOf([]byte(value))}
+	}
+	h := &history{}
+	h.add(newContainer("a", 1), 3)
+	h.add(newContainer("b", 2), 3)
+	assert.Equal(t, 2, h.latest().Version)
+
+	// redelivered
+	assert.False(t, h.newer(newContainer("b", 2)))
+	h.add(newContainer("b", 2), 3)
+	assert.Len(t, h.versions, 2)
+
+	// same value pushed again
+	assert.True(t, h.newer(newContainer("b", 3)))
+	// drivers without revisions
+	assert.True(t, h.newer(newContainer("c", 0)))
+
+	h.add(newContainer("c", 3), 3)
+	h.add(newContainer("d", 4), 3)
+	assert.Len(t, h.versions, 3)
+	assert.Nil(t, h.get(1))
+	assert.Equal(t, "b", string(h.get(2).KV.Value))
+}
+
+func TestRepository_References(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", `
+style: advanced
+rule:
+  - if: true
+    child:
+      ref: b
+`)
+	drv.Put("b", `
+style: basic
+rule:
+  age: 1
+`)
+	dispatcher := &mockDispatcher{}
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()), WithDispatcher(dispatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := repo.GetRuler("a").Calculate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, d["age"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	// the dependents of b are notified
+	drv.Put("b", `
+style: basic
+rule:
+  age: 2
+`)
+	// the cycle a -> b -> a is rejected
+	drv.Put("b", `
+style: ref
+name: a
+`)
+	drv.Put("c", `
+style: ref
+name: c
+`)
+	assert.Eventually(t, func() bool {
+		_, ok := repo.Status("c")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	d, err = repo.GetRuler("a").Calculate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d["age"])
+
+	s, _ := repo.Status("b")
+	assert.Equal(t, rule.LoadStateRejected, s.State)
+	assert.Contains(t, s.Error, "reference cycle b -> a -> b")
+	s, _ = repo.Status("c")
+	assert.Contains(t, s.Error, "reference cycle c -> c")
+
+	assert.Equal(t, []event{
+		{rule.EventTypeCreate, "a"},
+		{rule.EventTypeCreate, "b"},
+		{rule.EventTypeUpdate, "b"},
+		{rule.EventTypeUpdate, "a"},
+	}, dispatcher.Events())
+}
+
+type orderedDriver struct {
+	mockDriver
+	kvs []*rule.KeyValue
+}
+
+func (d *orderedDriver) All(ctx context.Context) ([]*rule.KeyValue, error) {
+	return d.kvs, nil
+}
+
+func TestRepository_Extends(t *testing.T) {
+	// the children come before their parents
+	drv := &orderedDriver{kvs: []*rule.KeyValue{
+		{Key: "c", Value: []byte("extends: b\nstyle: basic\nrule:\n  c: 1\n")},
+		{Key: "b", Value: []byte("extends: a\nstyle: basic\nrule:\n  b: 1\n")},
+		{Key: "a", Value: []byte("style: basic\nrule:\n  a: 1\n")},
+		{Key: "d", Value: []byte("extends: x\nstyle: basic\n")},
+	}}
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, repo.Names())
+	d, err := repo.GetRuler("c").Calculate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"a": 1, "b": 1, "c": 1}, d)
+
+	s, _ := repo.Status("d")
+	assert.Equal(t, rule.LoadStateRejected, s.State)
+	assert.Equal(t, "missing parent x", s.Error)
+}
+
+func TestRepository_ExtendsWatch(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("b", "extends: a\nstyle: basic\nrule:\n  b: 1\n")
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := repo.Status("b")
+	assert.Equal(t, "missing parent a", s.Error)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	// the waiting child is loaded once the parent comes
+	drv.Put("a", "style: basic\nrule:\n  a: 1\n")
+	assert.Eventually(t, func() bool {
+		return repo.GetRuler("b") != nil
+	}, time.Second, 10*time.Millisecond)
+	d, err := repo.GetRuler("b").Calculate(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, dto.Data{"a": 1, "b": 1}, d)
+
+	drv.Put("a", "extends: b\nstyle: basic\n")
+	assert.Eventually(t, func() bool {
+		return repo.Failed() == 1
+	}, time.Second, 10*time.Millisecond)
+	s, _ = repo.Status("a")
+	assert.Equal(t, "reference cycle a -> b -> a", s.Error)
+}
+
+func TestRepository_Segments(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", "style: advanced\nrule:\n  - if: segment(\"vip\")\n    then:\n      sms: 1\n  - if: true\n    then:\n      sms: 0\n")
+	drv.Put("b", "style: advanced\nrule:\n  - if: segment(\"new_user\")\n    then:\n      sms: 1\n")
+	drv.Put("segments/vip", "level >= 5")
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()), WithSegmentPrefix("segments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, repo.Count())
+	assert.Equal(t, 1, repo.Failed())
+	s, _ := repo.Status("b")
+	assert.Contains(t, s.Error, "unknown segment new_user")
+
+	d, err := repo.GetRuler("a").Calculate(dto.Payload{"level": 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, d["sms"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	// the rules using the segment are recompiled once it changes
+	drv.Put("segments/vip", `"level >= 10"`)
+	assert.Eventually(t, func() bool {
+		d, err := repo.GetRuler("a").Calculate(dto.Payload{"level": 5})
+		return err == nil && d["sms"] == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// the rejected rules are retried once the segment comes
+	drv.Put("segments/new_user", "days < 7")
+	assert.Eventually(t, func() bool {
+		return repo.GetRuler("b") != nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, repo.Count())
+	assert.Equal(t, 0, repo.Failed())
+}
+
+func TestRepository_SegmentsPinned(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", "style: advanced\nrule:\n  - if: segment(\"vip\")\n    then:\n      sms: 1\n")
+	drv.Put("segments/vip", "level >= 5")
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()), WithSegmentPrefix("segments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = repo.Watch(ctx)
+	}()
+
+	drv.Put("a", "style: advanced\nrule:\n  - if: segment(\"vip\")\n    then:\n      sms: 2\n")
+	assert.Eventually(t, func() bool {
+		return len(repo.History("a")) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, repo.Rollback("a", 1))
+
+	// the pinned version is recompiled in place
+	drv.Put("segments/vip", "level >= 10")
+	assert.Eventually(t, func() bool {
+		d, err := repo.GetRuler("a").Calculate(dto.Payload{"level": 5})
+		return err == nil && d["sms"] == nil
+	}, time.Second, 10*time.Millisecond)
+	s, _ := repo.Status("a")
+	assert.True(t, s.Pinned)
+	assert.Equal(t, 1, s.Version)
+	history := repo.History("a")
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, []int64{1, 3}, []int64{history[0].Revision, history[1].Revision})
+	}
+
+	// the kept values are not changed by the events
+	repo.rwLock.RLock()
+	for _, c := range repo.histories["a"].versions {
+		assert.Equal(t, rule.EventTypeUpdate, c.KV.Type)
+	}
+	repo.rwLock.RUnlock()
+}
+
+func TestRepository_WithFunction(t *testing.T) {
+	drv := driver.NewMemoryDriver()
+	drv.Put("a", "style: advanced\nrule:\n  - if: Upper(name) == \"FOO\"\n    then:\n      sms: 1\n")
+	repo, err := newDefaultRepository(drv, WithLogger(log.NewNopLogger()), WithFunction("Upper", strings.ToUpper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := repo.GetRuler("a").Calculate(map[string]interface{}{"name": "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, d["sms"])
+
+	// the struct payloads are not rewritten for the functions
+	type payload struct {
+		UserID   int    `structs:"user_id"`
+		Platform string `json:"platform"`
+	}
+	drv.Put("b", "style: switch\nby: platform\nrule:\n  - case: ios\n    style: basic\n    rule:\n      x: 1\n")
+	drv.Put("c", "style: rollout\nby: user_id\nsalt: a\nrule:\n  - weight: 100\n    then:\n      x: 1\n")
+	repo, err = newDefaultRepository(drv, WithLogger(log.NewNopLogger()), WithFunction("Upper", strings.ToUpper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c"} {
+		d, err = repo.GetRuler(name).Calculate(payload{UserID: 1, Platform: "ios"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, d["x"])
+	}
+}
+
+// newDefaultRepository returns the repository with its optional interfaces.
+func newDefaultRepository(driver rule.Driver, opts ...Option) (*defaultRepository, error) {
+	repo, err := NewRepository(driver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return repo.(*defaultRepository), nil
+}
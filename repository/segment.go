@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GGXXLL/rule"
+	"github.com/GGXXLL/rule/internal/entity"
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v3"
+)
+
+// WithSegmentPrefix loads the keys with the prefix as the segments shared by
+// the rules instead of rules, the name of a segment is the key without the
+// prefix. The rules using a segment are recompiled when it changes.
+func WithSegmentPrefix(prefix string) Option {
+	return func(r *defaultRepository) {
+		r.segmentPrefix = prefix
+	}
+}
+
+func (r *defaultRepository) isSegment(key string) bool {
+	return r.segmentPrefix != "" && strings.HasPrefix(key, r.segmentPrefix)
+}
+
+// setSegment sets or deletes the segment of the key, it returns the name.
+func (r *defaultRepository) setSegment(kv *rule.KeyValue) string {
+	name := strings.TrimPrefix(kv.Key, r.segmentPrefix)
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+	if kv.Type == rule.EventTypeDelete || len(kv.Value) == 0 {
+		delete(r.segments, name)
+		return name
+	}
+	var cond string
+	if err := yaml.Unmarshal(kv.Value, &cond); err != nil || cond == "" {
+		cond = strings.TrimSpace(string(kv.Value))
+	}
+	r.segments[name] = cond
+	return name
+}
+
+// segmentFunc looks up the segments of the repository and records the names
+// used by the container, including those missing.
+func (r *defaultRepository) segmentFunc(c *Container) entity.SegmentFunc {
+	if r.segmentPrefix == "" {
+		return nil
+	}
+	return func(name string) (string, bool) {
+		if !contains(c.Segments, name) {
+			c.Segments = append(c.Segments, name)
+		}
+		r.rwLock.RLock()
+		defer r.rwLock.RUnlock()
+		cond, ok := r.segments[name]
+		return cond, ok
+	}
+}
+
+// putSegment updates the segment and recompiles the rules using it, including
+// those rejected, see retry.
+func (r *defaultRepository) putSegment(ctx context.Context, kv *rule.KeyValue) {
+	name := r.setSegment(kv)
+	_ = level.Info(r.logger).Log("msg", fmt.Sprintf("条件片段已更新 %s", name))
+
+	r.rwLock.RLock()
+	var kvs []*rule.KeyValue
+	for _, c := range r.containers {
+		if contains(c.Segments, name) {
+			kvs = append(kvs, c.KV)
+		}
+	}
+	for _, rej := range r.rejected {
+		if contains(rej.Segments, name) && !containsKV(kvs, rej.KV) {
+			kvs = append(kvs, rej.KV)
+		}
+	}
+	r.rwLock.RUnlock()
+	for _, kv := range kvs {
+		r.retry(ctx, kv)
+	}
+}
+
+func containsKV(kvs []*rule.KeyValue, kv *rule.KeyValue) bool {
+	for _, k := range kvs {
+		if k == kv {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
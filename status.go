@@ -0,0 +1,45 @@
+package rule
+
+import "time"
+
+// LoadState is the state of the latest value of a rule key.
+type LoadState string
+
+const (
+	// LoadStateActive means the latest value is compiled and served.
+	LoadStateActive LoadState = "active"
+	// LoadStateRejected means the latest value fails to compile, the previous
+	// value is still served if any.
+	LoadStateRejected LoadState = "rejected"
+)
+
+// Status is the load status of a rule key seen from the driver.
+type Status struct {
+	Name  string    `json:"name"`
+	State LoadState `json:"state"`
+	// Version, Revision and Hash identify the active value served by
+	// GetRuler, they are empty if no value of the rule has compiled.
+	Version  int    `json:"version,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	// Pinned reports whether the rule is rolled back to Version, until the
+	// driver delivers a newer value.
+	Pinned bool `json:"pinned,omitempty"`
+	// RejectedRevision, Rejected and Error describe the latest value if it
+	// is rejected.
+	RejectedRevision int64  `json:"rejected_revision,omitempty"`
+	Rejected         string `json:"rejected,omitempty"`
+	Error            string `json:"error,omitempty"`
+	// UpdatedAt is the time the state changed.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Version is a compiled value of a rule kept in the history.
+type Version struct {
+	// Version numbers the compiled values of the rule from 1.
+	Version  int       `json:"version"`
+	Revision int64     `json:"revision"`
+	Hash     string    `json:"hash"`
+	Value    string    `json:"value"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
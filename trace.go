@@ -0,0 +1,181 @@
+package rule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GGXXLL/rule/dto"
+)
+
+// Trace records how a Ruler calculates the result. Each node of the rule that
+// has been visited is a Trace, the nodes not visited are omitted. It is JSON
+// serializable so that it can be attached to logs.
+type Trace struct {
+	// Path locates the node in the rule document, eg. rule[1].child.
+	Path  string `json:"path"`
+	Style string `json:"style,omitempty"`
+	// Condition is the if expression of an advanced rule item.
+	Condition string `json:"condition,omitempty"`
+	// By is the payload key of switch, range and rollout rules.
+	By string `json:"by,omitempty"`
+	// Ref is the name of the rule referenced by a ref rule.
+	Ref string `json:"ref,omitempty"`
+	// Let holds the values of the let bindings of an advanced rule.
+	Let map[string]interface{} `json:"let,omitempty"`
+	// Value is the evaluated condition, or the payload value of By.
+	Value interface{} `json:"value,omitempty"`
+	// Matched reports whether the condition is true, or whether a case matched.
+	Matched bool `json:"matched"`
+	// Case describes the case or branch that matched.
+	Case string `json:"case,omitempty"`
+	// Fallback reports whether the default is used.
+	Fallback bool     `json:"fallback,omitempty"`
+	Result   dto.Data `json:"result,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Children []*Trace `json:"children,omitempty"`
+
+	// shallow makes the children leaves, which record no children.
+	shallow bool
+	leaf    bool
+	// refs are the names of the rules being evaluated through the node, see
+	// Refer.
+	refs []string
+	// discard records nothing, the Trace only carries refs and ctx.
+	discard bool
+	// ctx is the context of the evaluation, see Context.
+	ctx context.Context
+}
+
+// ShallowTrace returns the Trace recording the node and its children only,
+// cheaper than a full one when only the branch taken is needed.
+func ShallowTrace() *Trace {
+	return &Trace{shallow: true}
+}
+
+// Context returns the context of the evaluation, which carries its clock and
+// location, see CalculateContext. It is never nil.
+func (t *Trace) Context() context.Context {
+	if t == nil || t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// WithContext returns a copy of t evaluating in ctx, it is meant for a Trace
+// not used yet, eg. ShallowTrace().WithContext(ctx).
+func (t *Trace) WithContext(ctx context.Context) *Trace {
+	c := *t
+	c.ctx = ctx
+	return &c
+}
+
+// Recording reports whether t records the nodes, false if t is nil or only
+// carries the context of the evaluation.
+func (t *Trace) Recording() bool {
+	return t != nil && !t.discard
+}
+
+// Child appends a child node at path key, it returns nil if t is nil, so
+// that tracing can be skipped by passing a nil Trace, or if t is a leaf of a
+// shallow trace, unless the rules referred to by t or the context must still
+// be carried, see Refer.
+func (t *Trace) Child(key string) *Trace {
+	if t == nil || t.discard {
+		return t
+	}
+	if t.leaf {
+		if len(t.refs) == 0 && t.ctx == nil {
+			return nil
+		}
+		return &Trace{discard: true, refs: t.refs, ctx: t.ctx}
+	}
+	c := &Trace{Path: key, leaf: t.shallow, refs: t.refs, ctx: t.ctx}
+	if t.Path != "" {
+		c.Path = t.Path + "." + key
+	}
+	t.Children = append(t.Children, c)
+	return c
+}
+
+// Index appends a child node at path key[i], it returns nil like Child.
+func (t *Trace) Index(key string, i int) *Trace {
+	if t == nil {
+		return nil
+	}
+	return t.Child(fmt.Sprintf("%s[%d]", key, i))
+}
+
+// Refer returns the Trace of the rule name evaluated on behalf of the node at
+// path key, eg. through a ref: a child of t, or if t records nothing, such as
+// a nil Trace, a Trace recording nothing but the rules being referred to. It
+// fails with an EvalError of kind ErrReferenceCycle if name is already being
+// evaluated through t, so that a cycle does not overflow the stack.
+func (t *Trace) Refer(key, name string) (*Trace, error) {
+	var (
+		refs []string
+		ctx  context.Context
+	)
+	if t != nil {
+		refs, ctx = t.refs, t.ctx
+	}
+	for i, n := range refs {
+		if n == name {
+			return nil, &EvalError{
+				Condition: name,
+				Kind:      ErrReferenceCycle,
+				Err:       errors.New(strings.Join(append(refs[i:len(refs):len(refs)], name), " -> ")),
+			}
+		}
+	}
+	c := t.Child(key)
+	if c == nil || c.discard {
+		c = &Trace{discard: true, ctx: ctx}
+	}
+	c.refs = append(refs[:len(refs):len(refs)], name)
+	return c, nil
+}
+
+// Record sets the result or error of the node, it is a no-op if t is nil or
+// records nothing.
+func (t *Trace) Record(data dto.Data, err error) {
+	if t == nil || t.discard {
+		return
+	}
+	if err != nil {
+		t.Error = err.Error()
+		return
+	}
+	t.Result = data
+}
+
+// Explainer is a Ruler that records the trace while calculating.
+type Explainer interface {
+	// Explain calculates like Calculate, and fills the trace if it is not nil.
+	Explain(payload interface{}, trace *Trace) (dto.Data, error)
+}
+
+// Explain calculates the payload and returns the trace along with the result.
+func Explain(rules Ruler, env interface{}) (dto.Data, *Trace, error) {
+	return explain(rules, env, &Trace{})
+}
+
+// ExplainContext explains like Explain, in ctx like CalculateContext.
+func ExplainContext(ctx context.Context, rules Ruler, env interface{}) (dto.Data, *Trace, error) {
+	return explain(rules, env, &Trace{ctx: ctx})
+}
+
+func explain(rules Ruler, env interface{}, trace *Trace) (dto.Data, *Trace, error) {
+	var (
+		data dto.Data
+		err  error
+	)
+	if e, ok := rules.(Explainer); ok {
+		data, err = e.Explain(env, trace)
+	} else {
+		data, err = Calculate(rules, env)
+	}
+	trace.Record(data, err)
+	return data, trace, err
+}
@@ -0,0 +1,43 @@
+package rule
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShallowTrace(t *testing.T) {
+	trace := ShallowTrace()
+	child := trace.Index("rule", 1)
+	if assert.NotNil(t, child) {
+		assert.Equal(t, "rule[1]", child.Path)
+	}
+	assert.Nil(t, child.Child("child"))
+	assert.Len(t, trace.Children, 1)
+
+	full := &Trace{}
+	assert.NotNil(t, full.Child("rule").Child("child"))
+}
+
+func TestTrace_Refer(t *testing.T) {
+	var trace *Trace
+	a, err := trace.Refer("ref", "a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	// records nothing but the rules referred to
+	assert.Same(t, a, a.Child("rule"))
+	b, err := a.Child("rule").Refer("ref", "b")
+	assert.NoError(t, err)
+	_, err = b.Refer("ref", "a")
+	assert.True(t, errors.Is(err, ErrReferenceCycle))
+	assert.EqualError(t, err, "reference cycle (a): a -> b -> a")
+
+	// a leaf of a shallow trace still tracks the rules referred to
+	shallow, err := ShallowTrace().Refer("ref", "a")
+	assert.NoError(t, err)
+	_, err = shallow.Child("rule").Child("rule").Refer("ref", "a")
+	assert.True(t, errors.Is(err, ErrReferenceCycle))
+	assert.Len(t, shallow.Children, 0)
+}
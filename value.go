@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"reflect"
 
 	"github.com/GGXXLL/rule/dto"
 	"github.com/antonmedv/expr"
@@ -17,6 +18,9 @@ type KeyValue struct {
 	Value []byte
 	Type  EventType
 	Err   error
+	// Revision is the version of the change, eg. the etcd mod revision.
+	// It is 0 if the driver does not support revisions.
+	Revision int64
 }
 
 type KvWatchChan <-chan *KeyValue
@@ -39,6 +43,11 @@ type CustomRuler interface {
 	CompileWithFunc(compileFunc CompileFunc) error
 }
 
+// Resolver looks up the rules referenced by other rules, Repository is a Resolver.
+type Resolver interface {
+	GetRuler(ruleName string) Ruler
+}
+
 type Repository interface {
 	// GetRuler returns the Ruler
 	GetRuler(ruleName string) Ruler
@@ -50,6 +59,78 @@ type Repository interface {
 	Count() int
 }
 
+// ListRepository is a Repository listing its rules.
+type ListRepository interface {
+	// Names returns the names of cached rules in order
+	Names() []string
+}
+
+// StatusRepository is a Repository reporting the load status of its rules.
+type StatusRepository interface {
+	// Failed returns the number of rules whose latest value fails to compile,
+	// the previous value of such a rule is still served if any.
+	Failed() int
+	// Status returns the load status of the rule, false if the rule has not
+	// been seen or has been deleted
+	Status(ruleName string) (Status, bool)
+	// Statuses returns the load status of every rule seen in order
+	Statuses() []Status
+}
+
+// HistoryRepository is a Repository keeping the compiled versions of its
+// rules.
+type HistoryRepository interface {
+	// History returns the compiled versions of the rule kept, the oldest first
+	History(ruleName string) []Version
+	// Rollback serves the version of the rule in the history until the driver
+	// delivers a newer value
+	Rollback(ruleName string, version int) error
+}
+
+// RevisionRepository is a Repository tracking the revision of the driver.
+type RevisionRepository interface {
+	// Revision returns the latest revision the repository has synced to
+	Revision() int64
+}
+
+// DefaultResultRepository is a Repository keeping the default_result of each
+// rule, parsed once the rule is loaded.
+type DefaultResultRepository interface {
+	// DefaultResult returns the default_result of the rule, false if the rule
+	// has none or is not loaded.
+	DefaultResult(ruleName string) (dto.Data, bool)
+}
+
+// RepositoryWrapper is a Repository wrapping another one, so that the optional
+// interfaces of the latter, such as RevisionRepository, are still found by
+// AsRepository.
+type RepositoryWrapper interface {
+	Unwrap() Repository
+}
+
+// AsRepository finds the first Repository in the chain of repo, following
+// Unwrap, which implements the interface pointed to by target, such as
+// *RevisionRepository, and sets target to it, like errors.As.
+func AsRepository(repo Repository, target interface{}) bool {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Interface {
+		panic("rule: target must be a non-nil pointer to an interface")
+	}
+	typ := v.Elem().Type()
+	for repo != nil {
+		if reflect.TypeOf(repo).Implements(typ) {
+			v.Elem().Set(reflect.ValueOf(repo))
+			return true
+		}
+		w, ok := repo.(RepositoryWrapper)
+		if !ok {
+			return false
+		}
+		repo = w.Unwrap()
+	}
+	return false
+}
+
 func Calculate(rules Ruler, env interface{}) (dto.Data, error) {
 	if _, ok := env.(expr.Option); ok {
 		return nil, fmt.Errorf("misused expr.Eval: second argument (env) should be passed without expr.Env")
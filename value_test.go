@@ -0,0 +1,38 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// plainRepository implements none of the optional interfaces.
+type plainRepository struct {
+	Repository
+}
+
+type revisionRepository struct {
+	plainRepository
+}
+
+func (revisionRepository) Revision() int64 { return 3 }
+
+type wrappedRepository struct {
+	Repository
+}
+
+func (w wrappedRepository) Unwrap() Repository { return w.Repository }
+
+func TestAsRepository(t *testing.T) {
+	var repo RevisionRepository
+	assert.False(t, AsRepository(plainRepository{}, &repo))
+	assert.False(t, AsRepository(wrappedRepository{plainRepository{}}, &repo))
+
+	if assert.True(t, AsRepository(wrappedRepository{wrappedRepository{revisionRepository{}}}, &repo)) {
+		assert.Equal(t, int64(3), repo.Revision())
+	}
+
+	assert.Panics(t, func() {
+		AsRepository(plainRepository{}, repo)
+	})
+}